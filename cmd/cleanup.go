@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/config"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/schedule"
+	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+)
+
+const cleanupHistoryLimit = 20
+
+// cleanupSchedule returns the configured cron schedule, or the cleanup
+// interval when none is set.
+func cleanupSchedule(cfg *config.Config) (schedule.Schedule, error) {
+	if cfg.Retention.Schedule == "" {
+		return schedule.Every(cfg.Retention.CleanupInterval), nil
+	}
+	return schedule.Parse(cfg.Retention.Schedule)
+}
+
+func cleanupOptions(cfg *config.Config) worker.CleanupOptions {
+	return worker.CleanupOptions{
+		MaxAge:        time.Duration(cfg.Retention.ExpiryDays) * 24 * time.Hour,
+		JobRetention:  cfg.Jobs.Retention,
+		KeepVersions:  cfg.Retention.KeepVersions,
+		VersionMaxAge: time.Duration(cfg.Retention.VersionExpiryDays) * 24 * time.Hour,
+		BatchSize:     cfg.Retention.BatchSize,
+		DryRun:        cfg.Retention.DryRun,
+	}
+}
+
+// runCleanupCommand implements "cleanup run", which runs cleanup once
+// unless another instance is already running it, and "cleanup history",
+// which lists recent runs. Pass -retention.dry_run=true to see what a run
+// would delete.
+func runCleanupCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "run" && args[0] != "history") {
+		fmt.Fprintln(os.Stderr, "usage: main cleanup run | main cleanup history [flags]")
+		return 2
+	}
+
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := database.InitDB(cfg.Database.URL); err != nil {
+		fmt.Fprintln(os.Stderr, "connecting to database:", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	if args[0] == "history" {
+		runs, err := database.GetMaintenanceRuns(ctx, worker.TaskCleanup, cleanupHistoryLimit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printRuns(runs)
+		return 0
+	}
+
+	if err := initMaintenance(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	run, err := worker.RunCleanup(ctx, worker.TriggerManual, cleanupOptions(cfg))
+	if errors.Is(err, database.ErrLockHeld) {
+		fmt.Fprintln(os.Stderr, "cleanup is already running on another instance")
+		return 1
+	}
+	if run != nil {
+		printRuns([]database.MaintenanceRun{*run})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printRuns(runs []database.MaintenanceRun) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tDURATION\tTRIGGER\tINSTANCE\tDRY RUN\tSTATUS\tSTATS\tERROR")
+	for _, run := range runs {
+		duration := "-"
+		if run.FinishedAt != nil {
+			duration = run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\t%s\t%s\n",
+			run.StartedAt.Format(time.RFC3339), duration, run.Trigger, run.Instance,
+			run.DryRun, run.Status, run.Stats, run.Error)
+	}
+	w.Flush()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/config"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+)
+
+const rotateBatchSize = 500
+
+// loadKeyring builds the master keyring from the configuration, or returns
+// nil when encryption is not configured.
+func loadKeyring(cfg *config.Config) (*encryption.Keyring, error) {
+	enc := cfg.Encryption
+	if enc.KeyFile != "" {
+		return encryption.LoadKeyFile(enc.KeyFile)
+	}
+	if enc.MasterKey == "" {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte, len(enc.RetiredKeys)+1)
+	for _, entry := range enc.RetiredKeys {
+		id, encoded, _ := strings.Cut(entry, ":")
+		key, err := encryption.ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("retired key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	key, err := encryption.ParseKey(enc.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	keys[enc.MasterKeyID] = key
+	return encryption.NewKeyring(enc.MasterKeyID, keys)
+}
+
+// runKeysCommand implements "keys generate", which prints a new master
+// key, and "keys rotate", which rewraps every data key not wrapped by the
+// active master key. Rotation leaves file content untouched.
+func runKeysCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "generate" && args[0] != "rotate") {
+		fmt.Fprintln(os.Stderr, "usage: main keys generate | main keys rotate [flags]")
+		return 2
+	}
+
+	if args[0] == "generate" {
+		key, err := encryption.GenerateKey()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(key)
+		return 0
+	}
+
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	keyring, err := loadKeyring(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loading master keys:", err)
+		return 1
+	}
+	if keyring == nil {
+		fmt.Fprintln(os.Stderr, "no master key configured")
+		return 1
+	}
+	if err := database.InitDB(cfg.Database.URL); err != nil {
+		fmt.Fprintln(os.Stderr, "connecting to database:", err)
+		return 1
+	}
+
+	rotated, failed, err := rotateKeys(context.Background(), keyring)
+	slog.Info("key rotation finished", "active_key", keyring.ActiveID(), "rotated", rotated, "failed", failed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func rotateKeys(ctx context.Context, keyring *encryption.Keyring) (rotated, failed int, err error) {
+	// Files that fail to rewrap are skipped so later batches still make
+	// progress; they are left for a later run.
+	skip := make(map[string]bool)
+	for {
+		keys, err := database.GetFileKeysNotWrappedBy(ctx, keyring.ActiveID(), rotateBatchSize+len(skip))
+		if err != nil {
+			return rotated, failed, err
+		}
+
+		progressed := false
+		for _, key := range keys {
+			if skip[key.FileID] {
+				continue
+			}
+			progressed = true
+
+			keyID, wrapped, err := keyring.Rewrap(key.KeyID, key.WrappedKey, key.FileID)
+			if err != nil {
+				slog.ErrorContext(ctx, "rewrapping data key failed", "file_id", key.FileID, "key_id", key.KeyID, "error", err)
+				skip[key.FileID] = true
+				failed++
+				continue
+			}
+			// A concurrent rotation may already have rewrapped the key.
+			updated, err := database.UpdateFileKey(ctx, key.FileID, key.KeyID, keyID, wrapped)
+			if err != nil {
+				return rotated, failed, err
+			}
+			if updated {
+				rotated++
+			}
+		}
+		if !progressed {
+			return rotated, failed, nil
+		}
+	}
+}
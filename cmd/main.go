@@ -1,44 +1,240 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/archive"
 	"github.com/manojkp08/22BCE11415_Backend/internal/auth"
 	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
 	"github.com/manojkp08/22BCE11415_Backend/internal/config"
 	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
 	"github.com/manojkp08/22BCE11415_Backend/internal/handlers"
+	"github.com/manojkp08/22BCE11415_Backend/internal/health"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/schedule"
+	"github.com/manojkp08/22BCE11415_Backend/internal/throttle"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
 	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+	"github.com/manojkp08/22BCE11415_Backend/pkg/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "config" {
+		os.Exit(runConfigCommand(args[1:]))
+	}
+	if len(args) > 0 && args[0] == "keys" {
+		os.Exit(runKeysCommand(args[1:]))
+	}
+	if len(args) > 0 && args[0] == "cleanup" {
+		os.Exit(runCleanupCommand(args[1:]))
+	}
+	if len(args) > 0 && args[0] == "reconcile" {
+		os.Exit(runReconcileCommand(args[1:]))
+	}
+	if len(args) > 0 && args[0] == "scrub" {
+		os.Exit(runScrubCommand(args[1:]))
+	}
+	serve(args)
+}
+
+func serve(args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Load configuration
-	cfg := config.LoadConfig()
-	go worker.StartCleanupWorker(24 * time.Hour) // Runs daily
+	cfg, err := config.Load(args)
+	if err != nil {
+		fatal("failed to load configuration", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal("failed to validate configuration", err)
+	}
+	if err := logging.Init(os.Stdout, cfg.Logging.Level, cfg.Logging.Format); err != nil {
+		fatal("failed to initialize logging", err)
+	}
+	auth.InitJWT(cfg.Auth.JWTSecret, cfg.Auth.TokenTTL)
+	signingKey := cfg.Auth.URLSigningKey
+	if signingKey == "" {
+		signingKey = cfg.Auth.JWTSecret
+	}
+	auth.InitPresign(signingKey, cfg.Auth.PresignMaxTTL)
+	if err := initStorage(cfg); err != nil {
+		fatal("failed to configure object store", err)
+	}
+	throttle.Upload = throttle.NewLimiter(cfg.Limits.Bandwidth.UploadBPS, cfg.Limits.Bandwidth.UserUploadBPS)
+	throttle.Download = throttle.NewLimiter(cfg.Limits.Bandwidth.DownloadBPS, cfg.Limits.Bandwidth.UserDownloadBPS)
+	upload.MaxSize = cfg.Limits.MaxUploadSize
+	upload.Global = upload.Policy{Allow: cfg.Uploads.AllowedTypes, Deny: cfg.Uploads.DeniedTypes}
+	archive.Default = archive.Limits{
+		MaxEntries:   cfg.Archives.MaxEntries,
+		MaxTotalSize: cfg.Archives.MaxTotalSize,
+		MaxRatio:     cfg.Archives.MaxRatio,
+	}
+	keyring, err := loadKeyring(cfg)
+	if err != nil {
+		fatal("failed to load encryption keys", err)
+	}
+	if keyring == nil {
+		slog.Warn("no encryption master key configured, uploads will be stored in plaintext")
+	}
+	encryption.Default = keyring
+	if cfg.Scan.ClamdAddr != "" {
+		scanner, err := scan.NewClamd(cfg.Scan.ClamdAddr, cfg.Scan.Timeout)
+		if err != nil {
+			fatal("failed to configure malware scanner", err)
+		}
+		scan.Default = scanner
+	} else {
+		slog.Warn("no clamd address configured, uploads will not be scanned for malware")
+	}
 
-	// Initialize database
-	err := database.InitDB(cfg.DBConnection)
+	// Initialize tracing; spans are exported only when an OTLP endpoint is set
+	var exporter sdktrace.SpanExporter
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		var err error
+		exporter, err = tracing.NewOTLPExporter(ctx)
+		if err != nil {
+			fatal("failed to create trace exporter", err)
+		}
+	}
+	shutdownTracing, err := tracing.Init(ctx, "fileshare", exporter)
 	if err != nil {
-		panic(err)
+		fatal("failed to initialize tracing", err)
+	}
+
+	// Initialize database
+	if err := database.InitDB(cfg.Database.URL); err != nil {
+		fatal("failed to connect to database", err)
+	}
+	database.DB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	database.DB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	database.DB.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	metrics.RegisterDBStats(database.DB)
+	if err := database.Migrate(ctx); err != nil {
+		fatal("failed to apply migrations", err)
 	}
 
 	// Initialize Redis cache
-	if err := cache.InitRedis(cfg.RedisAddr, cfg.RedisPassword); err != nil {
-		log.Fatal("Failed to connect to Redis: ", err)
+	if err := cache.InitRedis(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB); err != nil {
+		fatal("failed to connect to Redis", err)
 	}
 
 	// Initialize Google OAuth
 	auth.InitGoogleOAuth(
-		cfg.GoogleClientID,
-		cfg.GoogleSecret,
-		cfg.GoogleRedirectURL,
+		cfg.Auth.GoogleClientID,
+		cfg.Auth.GoogleClientSecret,
+		cfg.Auth.GoogleRedirectURL,
 	)
 
+	cleanupSched, err := cleanupSchedule(cfg)
+	if err != nil {
+		fatal("failed to parse cleanup schedule", err)
+	}
+	go worker.StartCleanupWorker(ctx, cleanupSched, cleanupOptions(cfg))
+	if cfg.Reconcile.Schedule != "" {
+		reconcileSched, err := schedule.Parse(cfg.Reconcile.Schedule)
+		if err != nil {
+			fatal("failed to parse reconcile schedule", err)
+		}
+		go worker.StartReconcileWorker(ctx, reconcileSched, reconcileOptions(cfg))
+	}
+	if cfg.Scrub.Schedule != "" {
+		scrubSched, err := schedule.Parse(cfg.Scrub.Schedule)
+		if err != nil {
+			fatal("failed to parse scrub schedule", err)
+		}
+		go worker.StartScrubWorker(ctx, scrubSched, worker.ScrubOptions{RateBPS: cfg.Scrub.RateBPS})
+	}
+
+	// Background jobs stop being claimed on shutdown; jobs in progress
+	// get until the shutdown timeout to finish before their lease lets
+	// another replica retry them.
+	jobs.DefaultMaxAttempts = cfg.Jobs.MaxAttempts
+	jobs.Register(worker.KindScanFile, worker.ScanFile)
+	jobs.Register(worker.KindGenerateThumbnails, worker.GenerateThumbnails)
+	jobs.Register(worker.KindExtractText, worker.ExtractText)
+	jobs.Register(worker.KindExtractArchive, worker.ExtractArchive)
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Run(ctx, jobs.Options{
+			Workers:      cfg.Jobs.Workers,
+			PollInterval: cfg.Jobs.PollInterval,
+			Lease:        cfg.Jobs.Lease,
+			BaseBackoff:  cfg.Jobs.BaseBackoff,
+			MaxBackoff:   cfg.Jobs.MaxBackoff,
+		})
+		close(jobsDone)
+	}()
+
 	// Set up router
-	router := gin.Default()
-	handlers.SetupRoutes(router)
+	router := gin.New()
+	router.Use(
+		otelgin.Middleware("fileshare"),
+		middleware.RequestID(),
+		middleware.RequestLogger(),
+		gin.Recovery(),
+	)
+	if err := handlers.SetupRoutes(router, cfg); err != nil {
+		fatal("failed to set up routes", err)
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           router,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	go func() {
+		slog.Info("listening", "addr", cfg.Server.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("server failed", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first so load balancers stop sending new requests,
+	// then give in-flight requests time to finish.
+	slog.Info("shutting down server")
+	health.SetShuttingDown()
+	time.Sleep(cfg.Server.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server forced to shut down", "error", err)
+	}
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		slog.Warn("background jobs still running at shutdown")
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("flushing traces failed", "error", err)
+	}
+}
 
-	router.Run(":8080")
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/config"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+)
+
+func reconcileOptions(cfg *config.Config) worker.ReconcileOptions {
+	return worker.ReconcileOptions{
+		GracePeriod: cfg.Reconcile.GracePeriod,
+		Repair:      cfg.Reconcile.Repair,
+	}
+}
+
+// runReconcileCommand implements "reconcile run", which compares stored
+// content with the files table and lists what does not match, and
+// "reconcile history", which lists recent runs. Pass
+// -reconcile.repair=true to fix what it finds.
+func runReconcileCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "run" && args[0] != "history") {
+		fmt.Fprintln(os.Stderr, "usage: main reconcile run | main reconcile history [flags]")
+		return 2
+	}
+
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := database.InitDB(cfg.Database.URL); err != nil {
+		fmt.Fprintln(os.Stderr, "connecting to database:", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	if args[0] == "history" {
+		runs, err := database.GetMaintenanceRuns(ctx, worker.TaskReconcile, cleanupHistoryLimit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printRuns(runs)
+		return 0
+	}
+
+	if err := initMaintenance(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	run, stats, err := worker.RunReconcile(ctx, worker.TriggerManual, reconcileOptions(cfg))
+	if errors.Is(err, database.ErrLockHeld) {
+		fmt.Fprintln(os.Stderr, "reconciliation is already running on another instance")
+		return 1
+	}
+	for _, id := range stats.MissingFiles {
+		fmt.Println("missing content:", id)
+	}
+	for _, version := range stats.MissingVersions {
+		fmt.Println("missing version content:", version)
+	}
+	for _, path := range stats.OrphanBlobs {
+		fmt.Println("orphaned blob:", path)
+	}
+	if stats.MissingContent > len(stats.MissingFiles) || stats.VersionsMissing > len(stats.MissingVersions) ||
+		stats.Orphans > len(stats.OrphanBlobs) {
+		fmt.Println("(list truncated, see the counts below)")
+	}
+	if run != nil {
+		fmt.Printf("files checked %d, missing content %d (marked %d, restored %d), versions checked %d, missing %d, blobs checked %d, orphans %d (%d bytes, deleted %d), failed %d\n",
+			stats.FilesChecked, stats.MissingContent, stats.MarkedMissing, stats.Restored,
+			stats.VersionsChecked, stats.VersionsMissing, stats.BlobsChecked, stats.Orphans, stats.OrphanBytes, stats.OrphansDeleted, stats.Failed)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !cfg.Reconcile.Repair && (stats.MissingContent > 0 || stats.Orphans > 0) {
+		fmt.Println("run with -reconcile.repair=true to fix these")
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/config"
+	"github.com/manojkp08/22BCE11415_Backend/internal/objectstore"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+)
+
+// initStorage points the storage packages at the configured upload and
+// quarantine directories and, when one is set, the object store that
+// direct uploads are staged in.
+func initStorage(cfg *config.Config) error {
+	storage.Dir = cfg.Storage.Dir
+	storage.QuarantineDir = cfg.Scan.QuarantineDir
+	if cfg.Objects.Endpoint != "" {
+		store, err := objectstore.New(cfg.Objects.Options())
+		if err != nil {
+			return err
+		}
+		objectstore.Default = store
+		objectstore.PartSize = cfg.Objects.PartSize
+	}
+	return nil
+}
+
+// initMaintenance sets up what a maintenance command needs, beyond the
+// database, to change files the way the server does: their content,
+// thumbnails and staged uploads, and the cached metadata that has to be
+// invalidated.
+func initMaintenance(cfg *config.Config) error {
+	if err := initStorage(cfg); err != nil {
+		return fmt.Errorf("configuring object store: %w", err)
+	}
+	if err := cache.InitRedis(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB); err != nil {
+		return fmt.Errorf("connecting to Redis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// Package archive lists and reads the members of uploaded zip and tar
+// archives in place, within limits that defuse decompression bombs.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits bound how much an archive may expand. MaxTotalSize and MaxRatio
+// apply to the sum of every member, so an archive is refused as a whole
+// rather than partly read.
+type Limits struct {
+	// MaxEntries is the most members an archive may have.
+	MaxEntries int
+	// MaxTotalSize is the most bytes its members may expand to.
+	MaxTotalSize int64
+	// MaxRatio is the most its members may expand to relative to the
+	// archive itself.
+	MaxRatio int
+}
+
+// Default applies to every archive read through the API.
+var Default = Limits{MaxEntries: 10000, MaxTotalSize: 1 << 30, MaxRatio: 100}
+
+// ratioGrace is how far any archive may expand regardless of MaxRatio, so
+// small archives of very compressible files are not refused.
+const ratioGrace = 1 << 20
+
+var (
+	ErrUnsupported = errors.New("unsupported archive type")
+	ErrLimit       = errors.New("archive exceeds extraction limits")
+	ErrNotFound    = errors.New("archive member not found")
+)
+
+// Entry describes one member of an archive.
+type Entry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Dir      bool      `json:"dir,omitempty"`
+}
+
+// Supported reports whether archives of mimeType can be read. Gzip files
+// are read as compressed tar archives.
+func Supported(mimeType string) bool {
+	switch baseType(mimeType) {
+	case "application/zip", "application/x-tar", "application/gzip":
+		return true
+	}
+	return false
+}
+
+// Walk calls fn for each member of the archive in r, which is size bytes
+// long, in archive order. The reader passed to fn yields the member's
+// content and is only valid until fn returns; it is empty for directories.
+// Links and other special members are skipped.
+// Walk stops at the first error fn returns and returns it. Once the
+// members exceed limits, Walk returns ErrLimit.
+func Walk(r io.ReaderAt, size int64, mimeType string, limits Limits, fn func(Entry, io.Reader) error) error {
+	switch baseType(mimeType) {
+	case "application/zip":
+		return walkZip(r, size, limits, fn)
+	case "application/x-tar":
+		return walkTar(io.NewSectionReader(r, 0, size), size, limits, fn)
+	case "application/gzip":
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkTar(gz, size, limits, fn)
+	}
+	return ErrUnsupported
+}
+
+// List returns the members of the archive in r.
+func List(r io.ReaderAt, size int64, mimeType string, limits Limits) ([]Entry, error) {
+	var entries []Entry
+	err := Walk(r, size, mimeType, limits, func(e Entry, _ io.Reader) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// errFound stops a walk once the wanted member has been handled.
+var errFound = errors.New("member found")
+
+// Read calls fn with the content of the regular file member called name,
+// or returns ErrNotFound. Members that come before it in a tar archive are
+// decompressed to reach it and count against limits.
+func Read(r io.ReaderAt, size int64, mimeType string, limits Limits, name string, fn func(Entry, io.Reader) error) error {
+	err := Walk(r, size, mimeType, limits, func(e Entry, content io.Reader) error {
+		if e.Dir || e.Name != name {
+			return nil
+		}
+		if err := fn(e, content); err != nil {
+			return err
+		}
+		return errFound
+	})
+	switch {
+	case errors.Is(err, errFound):
+		return nil
+	case err == nil:
+		return ErrNotFound
+	}
+	return err
+}
+
+// CleanName returns a member name as a relative slash-separated path, or
+// false if it is absolute or escapes the directory it is extracted into.
+func CleanName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if name == "" || strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", false
+	}
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
+
+// budget tracks how many more bytes an archive's members may expand to.
+type budget struct {
+	left int64
+}
+
+func newBudget(size int64, limits Limits) *budget {
+	left := limits.MaxTotalSize
+	if byRatio := max(size, 0) * int64(limits.MaxRatio); byRatio < left && byRatio >= 0 {
+		left = max(byRatio, ratioGrace)
+	}
+	return &budget{left: left}
+}
+
+// spend takes n bytes from the budget, failing with ErrLimit once it is
+// exhausted.
+func (b *budget) spend(n int64) error {
+	b.left -= n
+	if b.left < 0 {
+		return fmt.Errorf("%w: members expand beyond the size or ratio limit", ErrLimit)
+	}
+	return nil
+}
+
+// reader spends the budget as bytes are actually read, so members that
+// decompress to more than their headers claim are caught too.
+type reader struct {
+	r io.Reader
+	b *budget
+}
+
+func (r reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if berr := r.b.spend(int64(n)); berr != nil {
+		return n, berr
+	}
+	return n, err
+}
+
+func walkZip(r io.ReaderAt, size int64, limits Limits, fn func(Entry, io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	if len(zr.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrLimit, limits.MaxEntries)
+	}
+
+	// The central directory states every member's size up front, so a
+	// bomb is refused before anything is decompressed. The zip reader
+	// fails any member that inflates beyond its stated size.
+	b := newBudget(size, limits)
+	for _, f := range zr.File {
+		if f.UncompressedSize64 > uint64(limits.MaxTotalSize) {
+			return fmt.Errorf("%w: members expand beyond the size or ratio limit", ErrLimit)
+		}
+		if err := b.spend(int64(f.UncompressedSize64)); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range zr.File {
+		e := Entry{
+			Name:     f.Name,
+			Size:     int64(f.UncompressedSize64),
+			Modified: f.Modified,
+			Dir:      f.Mode().IsDir(),
+		}
+		if !e.Dir && !f.Mode().IsRegular() {
+			continue
+		}
+		if e.Dir {
+			e.Size = 0
+			if err := fn(e, strings.NewReader("")); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := walkZipFile(f, e, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkZipFile(f *zip.File, e Entry, fn func(Entry, io.Reader) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return fn(e, rc)
+}
+
+func walkTar(r io.Reader, size int64, limits Limits, fn func(Entry, io.Reader) error) error {
+	// A tar stream has no index: every header and member, including ones
+	// skipped, is decompressed to reach the next, so the budget is spent
+	// on the raw stream. Content read from members is counted separately
+	// because the holes of sparse files never appear in the stream.
+	tr := tar.NewReader(reader{r: r, b: newBudget(size, limits)})
+	read := newBudget(size, limits)
+	for n := 0; ; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == limits.MaxEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrLimit, limits.MaxEntries)
+		}
+
+		e := Entry{
+			Name:     hdr.Name,
+			Size:     hdr.Size,
+			Modified: hdr.ModTime,
+			Dir:      hdr.Typeflag == tar.TypeDir,
+		}
+		var content io.Reader = reader{r: tr, b: read}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			e.Size = 0
+			content = strings.NewReader("")
+		default:
+			continue
+		}
+		if err := fn(e, content); err != nil {
+			return err
+		}
+	}
+}
+
+// ReaderAt adapts a seekable stream, such as decrypted stored content, to
+// io.ReaderAt and returns its size.
+func ReaderAt(rs io.ReadSeeker) (io.ReaderAt, int64, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &seekReaderAt{rs: rs}, size, nil
+}
+
+type seekReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func baseType(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+	return mediaType
+}
@@ -0,0 +1,251 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// member is one file of a test archive.
+type member struct {
+	name    string
+	content []byte
+}
+
+func files(n int, size int) []member {
+	members := make([]member, n)
+	for i := range members {
+		members[i] = member{fmt.Sprintf("f%d.txt", i), bytes.Repeat([]byte{'a' + byte(i%26)}, size)}
+	}
+	return members
+}
+
+func zipArchive(t *testing.T, members ...member) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.Create(m.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(m.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, members ...member) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m.name, Mode: 0o644, Size: int64(len(m.content)), ModTime: time.Unix(0, 0)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(m.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sparseTar returns a tar archive holding one PAX sparse file of realSize
+// bytes that stores a single byte, the rest being a hole. archive/tar reads
+// such files as regular members but cannot write them, so the blocks are
+// built by hand.
+func sparseTar(name string, realSize int64) []byte {
+	var records []byte
+	for _, kv := range [][2]string{
+		{"GNU.sparse.major", "1"},
+		{"GNU.sparse.minor", "0"},
+		{"GNU.sparse.name", name},
+		{"GNU.sparse.realsize", fmt.Sprint(realSize)},
+	} {
+		records = append(records, paxRecord(kv[0], kv[1])...)
+	}
+	// The sparse map, one byte of data at offset 0, precedes the data
+	sparseMap := padBlock([]byte("1\n0\n1\n"))
+	data := padBlock([]byte("x"))
+
+	return bytes.Join([][]byte{
+		tarHeader("PaxHeaders/"+name, tar.TypeXHeader, int64(len(records))),
+		padBlock(records),
+		tarHeader("GNUSparseFile.0/"+name, tar.TypeReg, int64(len(sparseMap)+1)),
+		sparseMap,
+		data,
+		make([]byte, 1024),
+	}, nil)
+}
+
+// paxRecord encodes a PAX record, whose length prefix counts itself.
+func paxRecord(key, value string) []byte {
+	record := " " + key + "=" + value + "\n"
+	size := len(record) + len(fmt.Sprint(len(record)))
+	if len(fmt.Sprint(size)) > len(fmt.Sprint(len(record))) {
+		size++
+	}
+	return []byte(fmt.Sprint(size) + record)
+}
+
+// tarHeader builds a USTAR header block.
+func tarHeader(name string, typeflag byte, size int64) []byte {
+	block := make([]byte, 512)
+	octal := func(field []byte, n int64) {
+		copy(field, fmt.Sprintf("%0*o", len(field)-1, n))
+	}
+	copy(block[0:100], name)
+	octal(block[100:108], 0o644)
+	octal(block[108:116], 0)
+	octal(block[116:124], 0)
+	octal(block[124:136], size)
+	octal(block[136:148], 0)
+	block[156] = typeflag
+	copy(block[257:265], "ustar\x0000")
+
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return block
+}
+
+func padBlock(b []byte) []byte {
+	return append(b, make([]byte, (512-len(b)%512)%512)...)
+}
+
+func TestValidArchives(t *testing.T) {
+	members := []member{{"docs/readme.txt", []byte("hello")}, {"docs/notes.txt", []byte("world!")}}
+	tests := []struct {
+		mimeType string
+		content  []byte
+	}{
+		{"application/zip", zipArchive(t, members...)},
+		{"application/gzip", tarGzArchive(t, members...)},
+	}
+	for _, tt := range tests {
+		r := bytes.NewReader(tt.content)
+		entries, err := List(r, r.Size(), tt.mimeType, Default)
+		if err != nil {
+			t.Fatalf("%s: List: %v", tt.mimeType, err)
+		}
+		if len(entries) != 2 || entries[0].Name != "docs/readme.txt" || entries[1].Size != 6 {
+			t.Errorf("%s: entries = %+v", tt.mimeType, entries)
+		}
+
+		var got []byte
+		err = Read(r, r.Size(), tt.mimeType, Default, "docs/notes.txt", func(_ Entry, content io.Reader) error {
+			got, err = io.ReadAll(content)
+			return err
+		})
+		if err != nil || string(got) != "world!" {
+			t.Errorf("%s: Read = %q, %v, want world!", tt.mimeType, got, err)
+		}
+		err = Read(r, r.Size(), tt.mimeType, Default, "missing.txt", func(Entry, io.Reader) error { return nil })
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: Read of a missing member = %v, want ErrNotFound", tt.mimeType, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	// Zeros compress well beyond any sane ratio
+	bomb := member{"zeros.bin", make([]byte, 4<<20)}
+	few := Limits{MaxEntries: 3, MaxTotalSize: 1 << 30, MaxRatio: 100}
+	small := Limits{MaxEntries: 100, MaxTotalSize: 10 << 10, MaxRatio: 1 << 20}
+	tests := []struct {
+		name     string
+		mimeType string
+		content  []byte
+		limits   Limits
+	}{
+		{"zip entry count", "application/zip", zipArchive(t, files(4, 1)...), few},
+		{"zip total size", "application/zip", zipArchive(t, files(3, 5<<10)...), small},
+		{"zip ratio", "application/zip", zipArchive(t, bomb), Default},
+		{"tar.gz entry count", "application/gzip", tarGzArchive(t, files(4, 1)...), few},
+		{"tar.gz total size", "application/gzip", tarGzArchive(t, files(3, 5<<10)...), small},
+		{"tar.gz ratio", "application/gzip", tarGzArchive(t, bomb), Default},
+		{"tar sparse file", "application/x-tar", sparseTar("holes.bin", 64<<20), Default},
+	}
+	for _, tt := range tests {
+		r := bytes.NewReader(tt.content)
+		// Members are read in full, as an extraction would
+		err := Walk(r, r.Size(), tt.mimeType, tt.limits, func(_ Entry, content io.Reader) error {
+			_, err := io.Copy(io.Discard, content)
+			return err
+		})
+		if !errors.Is(err, ErrLimit) {
+			t.Errorf("%s: Walk = %v, want ErrLimit", tt.name, err)
+		}
+	}
+}
+
+func TestSparseFileListsWithinLimits(t *testing.T) {
+	// Listing reads no content, so the hole is never expanded
+	content := sparseTar("holes.bin", 64<<20)
+	entries, err := List(bytes.NewReader(content), int64(len(content)), "application/x-tar", Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Size != 64<<20 {
+		t.Errorf("entries = %+v, want holes.bin of 64MiB", entries)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	r := strings.NewReader("plain text")
+	if _, err := List(r, r.Size(), "text/plain", Default); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("List of text = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"docs/readme.txt", "docs/readme.txt", true},
+		{"./docs//readme.txt", "docs/readme.txt", true},
+		{`docs\readme.txt`, "docs/readme.txt", true},
+		{"docs/../readme.txt", "readme.txt", true},
+		{"dir/", "dir", true},
+		{"../x", "", false},
+		{"docs/../../x", "", false},
+		{`..\x`, "", false},
+		{"..", "", false},
+		{".", "", false},
+		{"", "", false},
+		{"/etc/passwd", "", false},
+		{`\Windows\system.ini`, "", false},
+		{"C:/Windows/system.ini", "", false},
+		{`c:\x`, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := CleanName(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("CleanName(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
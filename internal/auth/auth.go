@@ -7,7 +7,17 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
-var jwtKey = []byte("your_secret_key") // Change this in production
+var (
+	jwtKey   []byte
+	tokenTTL = 24 * time.Hour
+)
+
+// InitJWT sets the key used to sign and verify tokens and the lifetime of
+// newly issued tokens.
+func InitJWT(secret string, ttl time.Duration) {
+	jwtKey = []byte(secret)
+	tokenTTL = ttl
+}
 
 type Claims struct {
 	UserID string `json:"user_id"`
@@ -16,7 +26,7 @@ type Claims struct {
 }
 
 func GenerateJWTToken(userID, email string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(tokenTTL)
 	claims := &Claims{
 		UserID: userID,
 		Email:  email,
@@ -33,6 +43,9 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
 		return jwtKey, nil
 	})
 
@@ -46,3 +59,14 @@ func ValidateToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// IsForged reports whether err from ValidateToken means the token is
+// malformed or its signature does not verify, as opposed to a genuine
+// token that has merely expired.
+func IsForged(err error) bool {
+	var verr *jwt.ValidationError
+	if errors.As(err, &verr) {
+		return verr.Errors&(jwt.ValidationErrorMalformed|jwt.ValidationErrorUnverifiable|jwt.ValidationErrorSignatureInvalid) != 0
+	}
+	return err != nil
+}
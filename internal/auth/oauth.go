@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -17,6 +18,10 @@ var (
 	oauthStateString = "random"
 )
 
+// ErrInvalidCallback is returned when an OAuth callback carries a state or
+// code that doesn't check out, as opposed to a failure talking to Google.
+var ErrInvalidCallback = errors.New("invalid oauth callback")
+
 type GoogleUserInfo struct {
 	ID      string `json:"id"`
 	Email   string `json:"email"`
@@ -42,13 +47,13 @@ func HandleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 func HandleGoogleCallback(w http.ResponseWriter, r *http.Request) (*GoogleUserInfo, error) {
 	state := r.FormValue("state")
 	if state != oauthStateString {
-		return nil, fmt.Errorf("invalid oauth state")
+		return nil, fmt.Errorf("%w: state mismatch", ErrInvalidCallback)
 	}
 
 	code := r.FormValue("code")
 	token, err := googleOauthConfig.Exchange(context.Background(), code)
 	if err != nil {
-		return nil, fmt.Errorf("code exchange failed: %s", err.Error())
+		return nil, fmt.Errorf("%w: code exchange failed: %s", ErrInvalidCallback, err.Error())
 	}
 
 	response, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token.AccessToken)
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPresignTTL is how long a presigned URL lasts when the caller does
+// not say.
+const DefaultPresignTTL = 15 * time.Minute
+
+// Query parameters of a presigned URL.
+const (
+	PresignExpires   = "expires"
+	PresignUser      = "user_id"
+	PresignIP        = "ip"
+	PresignSignature = "signature"
+)
+
+var (
+	presignKey    []byte
+	presignMaxTTL = 24 * time.Hour
+)
+
+var (
+	ErrPresignTTL        = errors.New("presigned URL lifetime out of range")
+	ErrPresignInvalid    = errors.New("invalid URL signature")
+	ErrPresignExpired    = errors.New("presigned URL has expired")
+	ErrPresignIPMismatch = errors.New("presigned URL is bound to another IP address")
+)
+
+// InitPresign sets the secret presigned URLs are signed with and the
+// longest lifetime they may be given. The signing key is derived from the
+// secret, so the JWT secret can be reused without the two kinds of
+// signature ever being interchangeable.
+func InitPresign(secret string, maxTTL time.Duration) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("presigned URL"))
+	presignKey = mac.Sum(nil)
+	presignMaxTTL = maxTTL
+}
+
+// PresignMaxTTL is the longest lifetime a presigned URL may be given.
+func PresignMaxTTL() time.Duration {
+	return presignMaxTTL
+}
+
+// Presign returns the query parameters that let one request of method to
+// path act as userID until ttl from now, and when they expire. A non-empty
+// ip restricts the URL to clients with that address.
+func Presign(method, path, userID, ip string, ttl time.Duration) (url.Values, time.Time, error) {
+	if ttl <= 0 || ttl > presignMaxTTL {
+		return nil, time.Time{}, ErrPresignTTL
+	}
+	if ip != "" {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, time.Time{}, errors.New("invalid IP address")
+		}
+		ip = parsed.String()
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	query := url.Values{}
+	query.Set(PresignExpires, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(PresignUser, userID)
+	if ip != "" {
+		query.Set(PresignIP, ip)
+	}
+	query.Set(PresignSignature, presignature(method, path, userID, query.Get(PresignExpires), ip))
+	return query, expires, nil
+}
+
+// VerifyPresigned checks the presigned query parameters of a request of
+// method to path from clientIP and returns the user it acts as.
+func VerifyPresigned(method, path string, query url.Values, clientIP string) (string, error) {
+	userID, expires, ip := query.Get(PresignUser), query.Get(PresignExpires), query.Get(PresignIP)
+	want := presignature(method, path, userID, expires, ip)
+	if !hmac.Equal([]byte(want), []byte(query.Get(PresignSignature))) {
+		return "", ErrPresignInvalid
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", ErrPresignInvalid
+	}
+	if time.Now().After(time.Unix(unix, 0)) {
+		return "", ErrPresignExpired
+	}
+	if ip != "" && !net.ParseIP(ip).Equal(net.ParseIP(clientIP)) {
+		return "", ErrPresignIPMismatch
+	}
+	return userID, nil
+}
+
+// presignature signs every part of a presigned request that its URL fixes.
+func presignature(method, path, userID, expires, ip string) string {
+	mac := hmac.New(sha256.New, presignKey)
+	mac.Write([]byte(strings.Join([]string{method, path, userID, expires, ip}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
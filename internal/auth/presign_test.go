@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testPath   = "/files/42/download"
+	testUser   = "user-1"
+	testClient = "203.0.113.7"
+)
+
+func presigned(t *testing.T, ip string) url.Values {
+	t.Helper()
+	InitPresign("secret", time.Hour)
+	query, _, err := Presign("GET", testPath, testUser, ip, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+// resigned returns query with expires replaced and signed as Presign would.
+func resigned(query url.Values, expires string) url.Values {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set(PresignExpires, expires)
+	q.Set(PresignSignature, presignature("GET", testPath, q.Get(PresignUser), expires, q.Get(PresignIP)))
+	return q
+}
+
+func TestVerifyPresigned(t *testing.T) {
+	query := presigned(t, "")
+	userID, err := VerifyPresigned("GET", testPath, query, testClient)
+	if err != nil || userID != testUser {
+		t.Fatalf("VerifyPresigned = %q, %v, want %q", userID, err, testUser)
+	}
+
+	changed := func(key, value string) url.Values {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		if value == "" {
+			q.Del(key)
+		} else {
+			q.Set(key, value)
+		}
+		return q
+	}
+	past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		query  url.Values
+		want   error
+	}{
+		{"wrong method", "DELETE", testPath, query, ErrPresignInvalid},
+		{"wrong path", "GET", "/files/43/download", query, ErrPresignInvalid},
+		{"changed user", "GET", testPath, changed(PresignUser, "user-2"), ErrPresignInvalid},
+		{"extended expiry", "GET", testPath, changed(PresignExpires, "99999999999"), ErrPresignInvalid},
+		{"added IP binding", "GET", testPath, changed(PresignIP, testClient), ErrPresignInvalid},
+		{"missing expires", "GET", testPath, changed(PresignExpires, ""), ErrPresignInvalid},
+		{"missing signature", "GET", testPath, changed(PresignSignature, ""), ErrPresignInvalid},
+		{"signed malformed expires", "GET", testPath, resigned(query, "tomorrow"), ErrPresignInvalid},
+		{"signed empty expires", "GET", testPath, resigned(query, ""), ErrPresignInvalid},
+		{"expired", "GET", testPath, resigned(query, past), ErrPresignExpired},
+	}
+	for _, tt := range tests {
+		if _, err := VerifyPresigned(tt.method, tt.path, tt.query, testClient); !errors.Is(err, tt.want) {
+			t.Errorf("%s: VerifyPresigned = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyPresignedIP(t *testing.T) {
+	query := presigned(t, "2001:db8::1")
+	if query.Get(PresignIP) != "2001:db8::1" {
+		t.Fatalf("ip = %q", query.Get(PresignIP))
+	}
+	for _, ip := range []string{"2001:db8::1", "2001:0db8:0:0::1"} {
+		if _, err := VerifyPresigned("GET", testPath, query, ip); err != nil {
+			t.Errorf("VerifyPresigned from %s = %v, want ok", ip, err)
+		}
+	}
+	for _, ip := range []string{"2001:db8::2", testClient, ""} {
+		if _, err := VerifyPresigned("GET", testPath, query, ip); !errors.Is(err, ErrPresignIPMismatch) {
+			t.Errorf("VerifyPresigned from %q = %v, want ErrPresignIPMismatch", ip, err)
+		}
+	}
+}
+
+func TestPresignTTL(t *testing.T) {
+	InitPresign("secret", time.Hour)
+	for _, ttl := range []time.Duration{0, -time.Minute, time.Hour + time.Second} {
+		if _, _, err := Presign("GET", testPath, testUser, "", ttl); !errors.Is(err, ErrPresignTTL) {
+			t.Errorf("Presign with ttl %s = %v, want ErrPresignTTL", ttl, err)
+		}
+	}
+	if _, _, err := Presign("GET", testPath, testUser, "not an ip", time.Minute); err == nil {
+		t.Error("Presign accepted an invalid IP")
+	}
+}
+
+func TestPresignSecretChange(t *testing.T) {
+	query := presigned(t, "")
+	// Another secret invalidates every URL signed with the old one
+	InitPresign("rotated", time.Hour)
+	if _, err := VerifyPresigned("GET", testPath, query, testClient); !errors.Is(err, ErrPresignInvalid) {
+		t.Errorf("VerifyPresigned after rotating the secret = %v, want ErrPresignInvalid", err)
+	}
+}
@@ -2,42 +2,109 @@ package cache
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/go-redis/redis"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
 	Client *redis.Client
 )
 
-func InitRedis(addr, password string) error {
+func InitRedis(addr, password string, db int) error {
 	Client = redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
-		DB:       0,
+		DB:       db,
 	})
 
-	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
+	// The client's dial and read timeouts bound the ping
 	_, err := Client.Ping().Result()
 	if err != nil {
 		return err
 	}
-	log.Println("Connected to Redis")
+	slog.Info("connected to Redis", "addr", addr)
 	return nil
 }
 
-func SetFileMetadata(ctx context.Context, fileID string, data interface{}, ttl time.Duration) error {
-	return Client.Set("file:"+fileID, data, ttl).Err()
+// Ping checks that Redis is reachable.
+func Ping(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "cache.Ping")
+	defer func() { tracing.End(span, err) }()
+
+	return Client.WithContext(ctx).Ping().Err()
+}
+
+func SetFileMetadata(ctx context.Context, fileID string, data interface{}, ttl time.Duration) (err error) {
+	ctx, span := tracing.Start(ctx, "cache.SetFileMetadata", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	return Client.WithContext(ctx).Set("file:"+fileID, data, ttl).Err()
+}
+
+func GetFileMetadata(ctx context.Context, fileID string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "cache.GetFileMetadata", attribute.String("file.id", fileID))
+	ctx = logging.With(ctx, slog.String("file_id", fileID))
+	defer func() {
+		// A miss is an expected outcome, not a failed call.
+		if err == redis.Nil {
+			span.SetAttributes(attribute.Bool("cache.hit", false))
+			tracing.End(span, nil)
+			return
+		}
+		span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+		tracing.End(span, err)
+	}()
+
+	value, err := Client.WithContext(ctx).Get("file:" + fileID).Result()
+	switch {
+	case err == redis.Nil:
+		metrics.CacheRequests.WithLabelValues("miss").Inc()
+	case err != nil:
+		metrics.CacheRequests.WithLabelValues("error").Inc()
+		slog.WarnContext(ctx, "reading file metadata from cache failed", "error", err)
+	default:
+		metrics.CacheRequests.WithLabelValues("hit").Inc()
+	}
+	return value, err
+}
+
+// InvalidateCache deletes key. Without a Redis connection there is no
+// cache to invalidate, so it does nothing.
+func InvalidateCache(ctx context.Context, key string) (err error) {
+	if Client == nil {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "cache.InvalidateCache", attribute.String("cache.key", key))
+	defer func() { tracing.End(span, err) }()
+
+	return Client.WithContext(ctx).Del(key).Err()
 }
 
-func GetFileMetadata(ctx context.Context, fileID string) (string, error) {
-	return Client.Get("file:" + fileID).Result()
+// Publish sends message to every subscriber of channel, on any replica.
+func Publish(ctx context.Context, channel, message string) (err error) {
+	ctx, span := tracing.Start(ctx, "cache.Publish", attribute.String("cache.channel", channel))
+	defer func() { tracing.End(span, err) }()
+
+	return Client.WithContext(ctx).Publish(channel, message).Err()
 }
 
-func InvalidateCache(ctx context.Context, key string) error {
-	return Client.Del(key).Err()
+// Subscribe listens on channel. Callers must close the subscription.
+func Subscribe(ctx context.Context, channel string) (_ *redis.PubSub, err error) {
+	ctx, span := tracing.Start(ctx, "cache.Subscribe", attribute.String("cache.channel", channel))
+	defer func() { tracing.End(span, err) }()
+
+	pubsub := Client.WithContext(ctx).Subscribe(channel)
+	// Wait for the subscription to be confirmed so no message published
+	// after Subscribe returns is missed.
+	if _, err = pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	return pubsub, nil
 }
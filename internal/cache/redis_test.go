@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeRedis serves just enough of the Redis protocol for these tests:
+// every GET misses and every SUBSCRIBE is confirmed.
+func fakeRedis(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveRedis(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveRedis(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			io.WriteString(conn, "$-1\r\n")
+		case "SUBSCRIBE":
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		default:
+			io.WriteString(conn, "+OK\r\n")
+		}
+	}
+}
+
+// readCommand reads one command, sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+func TestRequestSpanTree(t *testing.T) {
+	exporter := recordSpans(t)
+	Client = redis.NewClient(&redis.Options{Addr: fakeRedis(t)})
+	t.Cleanup(func() { Client.Close(); Client = nil })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(otelgin.Middleware("test"))
+	router.GET("/files/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if _, err := GetFileMetadata(ctx, c.Param("id")); err != redis.Nil {
+			t.Errorf("GetFileMetadata error = %v, want redis.Nil", err)
+		}
+		pubsub, err := Subscribe(ctx, "uploads:1")
+		if err != nil {
+			t.Errorf("Subscribe: %v", err)
+			return
+		}
+		pubsub.Close()
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/files/42", nil))
+
+	spans := exporter.GetSpans()
+	var server *tracetest.SpanStub
+	byName := make(map[string]tracetest.SpanStub)
+	for i, span := range spans {
+		if span.SpanKind == trace.SpanKindServer {
+			server = &spans[i]
+			continue
+		}
+		byName[span.Name] = span
+	}
+	if server == nil {
+		t.Fatalf("no server span among %d spans", len(spans))
+	}
+
+	for _, name := range []string{"cache.GetFileMetadata", "cache.Subscribe"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("%s parent = %s, want the server span %s", name, span.Parent.SpanID(), server.SpanContext.SpanID())
+		}
+		if span.Status.Code == codes.Error {
+			t.Errorf("%s status = error: %s", name, span.Status.Description)
+		}
+	}
+	if !hasAttribute(byName["cache.GetFileMetadata"].Attributes, attribute.Bool("cache.hit", false)) {
+		t.Errorf("cache.GetFileMetadata attributes = %v, want cache.hit=false", byName["cache.GetFileMetadata"].Attributes)
+	}
+	if !hasAttribute(byName["cache.Subscribe"].Attributes, attribute.String("cache.channel", "uploads:1")) {
+		t.Errorf("cache.Subscribe attributes = %v, want cache.channel=uploads:1", byName["cache.Subscribe"].Attributes)
+	}
+}
+
+func TestFailedCallRecordsError(t *testing.T) {
+	exporter := recordSpans(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	Client = redis.NewClient(&redis.Options{Addr: addr, MaxRetries: 0})
+	t.Cleanup(func() { Client.Close(); Client = nil })
+
+	if _, err := Subscribe(t.Context(), "uploads:1"); err == nil {
+		t.Fatal("Subscribe succeeded without a server")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "cache.Subscribe" {
+		t.Fatalf("spans = %v, want one cache.Subscribe span", spans)
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Errorf("status = %v, want error", spans[0].Status.Code)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInvalidateCacheWithoutRedis(t *testing.T) {
+	Client = nil
+	if err := InvalidateCache(t.Context(), "file:1"); err != nil {
+		t.Fatalf("InvalidateCache without Redis: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package database
+
+import (
+	"context"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ExtractedFileExists reports whether a file called name in folder has
+// already been extracted from the archive sourceID.
+func ExtractedFileExists(ctx context.Context, sourceID, folder, name string) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "database.ExtractedFileExists", attribute.String("file.id", sourceID))
+	defer func() { tracing.End(span, err) }()
+
+	var exists bool
+	err = DB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM files WHERE source_id = $1 AND folder = $2 AND name = $3)",
+		sourceID, folder, name,
+	).Scan(&exists)
+	return exists, err
+}
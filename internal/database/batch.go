@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/lib/pq"
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GetFilesByIDs returns the files among ids that exist, in no particular
+// order.
+func GetFilesByIDs(ctx context.Context, ids []string) (_ []File, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFilesByIDs", attribute.Int("files.count", len(ids)))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE id = ANY($1::uuid[])",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var file File
+		if err := rows.Scan(fileFields(&file)...); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// GetFilesInFolder returns up to limit of userID's files in folder and its
+// subfolders, ordered by folder and name.
+func GetFilesInFolder(ctx context.Context, userID, folder string, limit int) (_ []File, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFilesInFolder",
+		attribute.String("user.id", userID),
+		attribute.String("file.folder", folder),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	prefix := folder + "/"
+	if folder == "/" {
+		prefix = "/"
+	}
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+fileColumns+`
+		FROM files
+		WHERE user_id = $1 AND (folder = $2 OR starts_with(folder, $3))
+		ORDER BY folder, name, created_at
+		LIMIT $4`,
+		userID, folder, prefix, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var file File
+		if err := rows.Scan(fileFields(&file)...); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// MoveFiles puts userID's files among ids into folder and returns the IDs
+// that were moved.
+func MoveFiles(ctx context.Context, userID string, ids []string, folder string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "database.MoveFiles",
+		attribute.String("user.id", userID),
+		attribute.String("file.folder", folder),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	return updateFiles(ctx, "UPDATE files SET folder = $3 WHERE id = ANY($1::uuid[]) AND user_id = $2 RETURNING id",
+		pq.Array(ids), userID, folder)
+}
+
+// SetFilesPublic shares or unshares userID's files among ids and returns
+// the IDs that were updated.
+func SetFilesPublic(ctx context.Context, userID string, ids []string, public bool) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "database.SetFilesPublic",
+		attribute.String("user.id", userID),
+		attribute.Bool("file.public", public),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	return updateFiles(ctx, "UPDATE files SET is_public = $3 WHERE id = ANY($1::uuid[]) AND user_id = $2 RETURNING id",
+		pq.Array(ids), userID, public)
+}
+
+// updateFiles runs an UPDATE returning file IDs and invalidates the cached
+// metadata of each.
+func updateFiles(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updated []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		updated = append(updated, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range updated {
+		if err := cache.InvalidateCache(ctx, "file:"+id); err != nil {
+			slog.WarnContext(ctx, "invalidating file cache failed", "file_id", id, "error", err)
+		}
+	}
+	return updated, nil
+}
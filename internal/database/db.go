@@ -3,12 +3,15 @@ package database
 import (
 	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var DB *sql.DB
@@ -25,13 +28,16 @@ func InitDB(connectionString string) error {
 		return err
 	}
 
-	log.Println("Connected to PostgreSQL database")
+	slog.Info("connected to PostgreSQL database")
 	return nil
 }
 
-func GetUserByID(userID string) (*User, error) {
+func GetUserByID(ctx context.Context, userID string) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetUserByID", attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
 	var user User
-	err := DB.QueryRow(
+	err = DB.QueryRowContext(ctx,
 		"SELECT id, email, name, created_at FROM users WHERE id = $1",
 		userID,
 	).Scan(
@@ -43,9 +49,12 @@ func GetUserByID(userID string) (*User, error) {
 	return &user, nil
 }
 
-func GetOrCreateUser(email, name string) (*User, error) {
+func GetOrCreateUser(ctx context.Context, email, name string) (_ *User, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetOrCreateUser")
+	defer func() { tracing.End(span, err) }()
+
 	var user User
-	err := DB.QueryRow("SELECT id, email, name, created_at FROM users WHERE email = $1", email).Scan(
+	err = DB.QueryRowContext(ctx, "SELECT id, email, name, created_at FROM users WHERE email = $1", email).Scan(
 		&user.ID, &user.Email, &user.Name, &user.CreatedAt,
 	)
 
@@ -56,7 +65,7 @@ func GetOrCreateUser(email, name string) (*User, error) {
 		user.Name = name
 		user.CreatedAt = time.Now()
 
-		_, err := DB.Exec(
+		_, err = DB.ExecContext(ctx,
 			"INSERT INTO users (id, email, name, created_at) VALUES ($1, $2, $3, $4)",
 			user.ID, user.Email, user.Name, user.CreatedAt,
 		)
@@ -75,21 +84,81 @@ func generateUUID() string {
 	return uuid.New().String()
 }
 
-func CreateFile(file File) (*File, error) {
-	_, err := DB.Exec(
-		"INSERT INTO files (id, user_id, name, path, size, mime_type, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-		file.ID, file.UserID, file.Name, file.Path, file.Size, file.MimeType, time.Now(),
+// fileColumns lists the files columns in the order fileFields scans them.
+const fileColumns = "id, user_id, name, path, size, mime_type, created_at, is_public, scan_status, scan_signature, key_id, wrapped_key, content_missing_at, " +
+	"ARRAY(SELECT size FROM thumbnails WHERE thumbnails.file_id = files.id ORDER BY size), " +
+	"ARRAY(SELECT tags.name FROM file_tags JOIN tags ON tags.id = file_tags.tag_id WHERE file_tags.file_id = files.id ORDER BY tags.name), " +
+	"files.metadata, files.version, files.sha256, files.folder, files.source_id, files.corrupt_at"
+
+func fileFields(file *File) []any {
+	return []any{
+		&file.ID, &file.UserID, &file.Name, &file.Path, &file.Size,
+		&file.MimeType, &file.CreatedAt, &file.IsPublic,
+		&file.ScanStatus, &file.ScanSignature, &file.KeyID, &file.WrappedKey,
+		&file.ContentMissingAt, pq.Array(&file.Thumbnails), pq.Array(&file.Tags), &file.Metadata,
+		&file.Version, &file.SHA256, &file.Folder, &file.SourceID, &file.CorruptAt,
+	}
+}
+
+// CreateFile records a new file with its tags and metadata and enqueues its
+// post-upload jobs in the same transaction, so the jobs run even if the
+// process dies right after.
+func CreateFile(ctx context.Context, file File, jobs ...Job) (_ *File, err error) {
+	ctx, span := tracing.Start(ctx, "database.CreateFile", attribute.String("file.id", file.ID))
+	defer func() { tracing.End(span, err) }()
+
+	if file.Folder == "" {
+		file.Folder = "/"
+	}
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO files (id, user_id, name, path, size, mime_type, created_at, scan_status, key_id, wrapped_key, metadata, sha256, folder, source_id, search_vector)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, file_name_vector($3))`,
+		file.ID, file.UserID, file.Name, file.Path, file.Size, file.MimeType, time.Now(), file.ScanStatus,
+		file.KeyID, file.WrappedKey, file.Metadata, file.SHA256, file.Folder, file.SourceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO file_versions (file_id, version, path, size, mime_type, sha256, uploaded_by, scan_status)
+		VALUES ($1, 1, $2, $3, $4, $5, $6, $7)`,
+		file.ID, file.Path, file.Size, file.MimeType, file.SHA256, file.UserID, file.ScanStatus,
 	)
 	if err != nil {
 		return nil, err
 	}
+	file.Version = 1
+	if len(file.Tags) > 0 {
+		if err := setFileTags(ctx, tx, file.UserID, file.ID, file.Tags); err != nil {
+			return nil, err
+		}
+	}
+	for i := range jobs {
+		if err := insertJob(ctx, tx, &jobs[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return &file, nil
 }
 
-func GetFilesByUserID(userID string) ([]File, error) {
-	rows, err := DB.Query(
-		"SELECT id, user_id, name, path, size, mime_type, created_at, is_public FROM files WHERE user_id = $1",
-		userID,
+// GetFilesByUserID returns userID's files that match filter.
+func GetFilesByUserID(ctx context.Context, userID string, filter FileFilter) (_ []File, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFilesByUserID", attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	clause, args := fileFilterClause(filter, []any{userID})
+	rows, err := DB.QueryContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE user_id = $1"+clause,
+		args...,
 	)
 	if err != nil {
 		return nil, err
@@ -99,11 +168,7 @@ func GetFilesByUserID(userID string) ([]File, error) {
 	var files []File
 	for rows.Next() {
 		var file File
-		err := rows.Scan(
-			&file.ID, &file.UserID, &file.Name, &file.Path, &file.Size,
-			&file.MimeType, &file.CreatedAt, &file.IsPublic,
-		)
-		if err != nil {
+		if err := rows.Scan(fileFields(&file)...); err != nil {
 			return nil, err
 		}
 		files = append(files, file)
@@ -112,64 +177,69 @@ func GetFilesByUserID(userID string) ([]File, error) {
 	return files, nil
 }
 
-func GetFileByID(fileID string) (*File, error) {
+func GetFileByID(ctx context.Context, fileID string) (_ *File, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFileByID", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
 	var file File
-	err := DB.QueryRow(
-		"SELECT id, user_id, name, path, size, mime_type, created_at, is_public FROM files WHERE id = $1",
+	err = DB.QueryRowContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE id = $1",
 		fileID,
-	).Scan(
-		&file.ID, &file.UserID, &file.Name, &file.Path, &file.Size,
-		&file.MimeType, &file.CreatedAt, &file.IsPublic,
-	)
+	).Scan(fileFields(&file)...)
 	if err != nil {
 		return nil, err
 	}
 	return &file, nil
 }
 
-// GetExpiredFiles returns all files that are older than 7 days and not public
-func GetExpiredFiles() ([]File, error) {
-	rows, err := DB.Query(
-		`SELECT id, user_id, name, path, size, mime_type, created_at, is_public 
-		FROM files 
-		WHERE created_at < NOW() - INTERVAL '7 DAYS' 
-		AND is_public = false`,
+// FileCursor marks a position in files ordered by creation time and ID.
+// The zero value starts at the beginning.
+type FileCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// GetExpiredFiles returns up to limit private files created before cutoff,
+// ordered by creation time and ID, starting after cursor. Pass the last
+// file returned as the next cursor to page through them.
+func GetExpiredFiles(ctx context.Context, cutoff time.Time, cursor FileCursor, limit int) (_ []File, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetExpiredFiles")
+	defer func() { tracing.End(span, err) }()
+
+	if cursor.ID == "" {
+		cursor.ID = "00000000-0000-0000-0000-000000000000"
+	}
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+fileColumns+`
+		FROM files
+		WHERE created_at < $1
+		AND is_public = false
+		AND (created_at, id) > ($2, $3::uuid)
+		ORDER BY created_at, id
+		LIMIT $4`,
+		cutoff, cursor.CreatedAt, cursor.ID, limit,
 	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return []File{}, nil // Return empty slice if no expired files
-		}
-		log.Printf("Error querying expired files: %v", err)
+		slog.ErrorContext(ctx, "querying expired files failed", "error", err)
 		return nil, err
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
+			slog.WarnContext(ctx, "closing rows failed", "error", err)
 		}
 	}()
 
 	var expiredFiles []File
 	for rows.Next() {
 		var file File
-		err := rows.Scan(
-			&file.ID,
-			&file.UserID,
-			&file.Name,
-			&file.Path,
-			&file.Size,
-			&file.MimeType,
-			&file.CreatedAt,
-			&file.IsPublic,
-		)
-		if err != nil {
-			log.Printf("Error scanning file row: %v", err)
-			continue // Skip problematic rows but continue processing others
+		if err := rows.Scan(fileFields(&file)...); err != nil {
+			return nil, err
 		}
 		expiredFiles = append(expiredFiles, file)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("Error during rows iteration: %v", err)
+		slog.ErrorContext(ctx, "iterating expired files failed", "error", err)
 		return nil, err
 	}
 
@@ -177,19 +247,23 @@ func GetExpiredFiles() ([]File, error) {
 }
 
 // DeleteFile removes a file record from the database
-func DeleteFile(fileID string) error {
+func DeleteFile(ctx context.Context, fileID string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.DeleteFile", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+	ctx = logging.With(ctx, slog.String("file_id", fileID))
+
 	// Start a transaction
-	tx, err := DB.Begin()
+	tx, err := DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
+		slog.ErrorContext(ctx, "starting transaction failed", "error", err)
 		return err
 	}
 
 	// Try to delete the file
-	result, err := tx.Exec("DELETE FROM files WHERE id = $1", fileID)
+	result, err := tx.ExecContext(ctx, "DELETE FROM files WHERE id = $1", fileID)
 	if err != nil {
 		tx.Rollback()
-		log.Printf("Error deleting file %s: %v", fileID, err)
+		slog.ErrorContext(ctx, "deleting file row failed", "error", err)
 		return err
 	}
 
@@ -197,7 +271,7 @@ func DeleteFile(fileID string) error {
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		tx.Rollback()
-		log.Printf("Error checking rows affected: %v", err)
+		slog.ErrorContext(ctx, "checking rows affected failed", "error", err)
 		return err
 	}
 
@@ -207,14 +281,14 @@ func DeleteFile(fileID string) error {
 	}
 
 	// Invalidate cache if exists
-	if err := cache.InvalidateCache(context.Background(), fileID); err != nil {
-		log.Printf("Error invalidating cache for file %s: %v", fileID, err)
+	if err := cache.InvalidateCache(ctx, "file:"+fileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
 		// Don't fail the operation just because cache invalidation failed
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
+		slog.ErrorContext(ctx, "committing transaction failed", "error", err)
 		return err
 	}
 
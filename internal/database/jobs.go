@@ -0,0 +1,329 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+const jobColumns = "id, kind, payload, COALESCE(user_id::text, ''), status, attempts, max_attempts, run_at, last_error, created_at, updated_at, finished_at"
+
+func jobFields(job *Job) []any {
+	return []any{
+		&job.ID, &job.Kind, &job.Payload, &job.UserID, &job.Status, &job.Attempts,
+		&job.MaxAttempts, &job.RunAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt, &job.FinishedAt,
+	}
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertJob(ctx context.Context, db execer, job *Job) error {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	if len(job.Payload) == 0 {
+		job.Payload = []byte("{}")
+	}
+	var userID any
+	if job.UserID != "" {
+		userID = job.UserID
+	}
+	job.Status = JobQueued
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO jobs (id, kind, payload, user_id, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()))`,
+		job.ID, job.Kind, string(job.Payload), userID, job.MaxAttempts, nullTime(job.RunAt),
+	)
+	return err
+}
+
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// EnqueueJob adds a job to the queue. A zero RunAt runs it immediately.
+func EnqueueJob(ctx context.Context, job Job) (_ *Job, err error) {
+	ctx, span := tracing.Start(ctx, "database.EnqueueJob", attribute.String("job.kind", job.Kind))
+	defer func() { tracing.End(span, err) }()
+
+	if err := insertJob(ctx, DB, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimJob locks the next runnable job for worker until the lease expires
+// and counts the attempt. Jobs whose lease has lapsed, because their worker
+// died, are claimed again while they have attempts left; BuryLapsedJobs
+// handles the rest. It returns sql.ErrNoRows when nothing is due.
+func ClaimJob(ctx context.Context, worker string, kinds []string, lease time.Duration) (_ *Job, err error) {
+	ctx, span := tracing.Start(ctx, "database.ClaimJob")
+	defer func() {
+		if err == sql.ErrNoRows {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+
+	var job Job
+	err = DB.QueryRowContext(ctx,
+		`UPDATE jobs SET
+			status = 'running',
+			attempts = attempts + 1,
+			locked_by = $1,
+			locked_until = NOW() + $2::float8 * INTERVAL '1 millisecond',
+			updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($3)
+			AND ((status = 'queued' AND run_at <= NOW())
+				OR (status = 'running' AND locked_until < NOW() AND attempts < max_attempts))
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		worker, lease.Milliseconds(), pq.Array(kinds),
+	).Scan(jobFields(&job)...)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// BuryLapsedJobs moves jobs whose lease lapsed during their final attempt
+// to the dead-letter state and returns them. Without it a job that kills
+// its worker every time would be reclaimed forever.
+func BuryLapsedJobs(ctx context.Context, kinds []string) (_ []Job, err error) {
+	ctx, span := tracing.Start(ctx, "database.BuryLapsedJobs")
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		`UPDATE jobs SET status = 'dead', last_error = 'worker lost during the final attempt (lease expired)',
+			locked_by = '', locked_until = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE kind = ANY($1) AND status = 'running' AND locked_until < NOW() AND attempts >= max_attempts
+		RETURNING `+jobColumns,
+		pq.Array(kinds),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var job Job
+		if err := rows.Scan(jobFields(&job)...); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// CompleteJob marks a job claimed by worker as succeeded.
+func CompleteJob(ctx context.Context, jobID, worker string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.CompleteJob", attribute.String("job.id", jobID))
+	defer func() { tracing.End(span, err) }()
+
+	_, err = DB.ExecContext(ctx,
+		`UPDATE jobs SET status = 'succeeded', last_error = '', locked_by = '', locked_until = NULL,
+			finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2`,
+		jobID, worker,
+	)
+	return err
+}
+
+// RetryJob requeues a failed job claimed by worker to run at runAt.
+func RetryJob(ctx context.Context, jobID, worker string, runAt time.Time, reason string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.RetryJob", attribute.String("job.id", jobID))
+	defer func() { tracing.End(span, err) }()
+
+	_, err = DB.ExecContext(ctx,
+		`UPDATE jobs SET status = 'queued', run_at = $3, last_error = $4, locked_by = '', locked_until = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2`,
+		jobID, worker, runAt, reason,
+	)
+	return err
+}
+
+// BuryJob moves a job claimed by worker to the dead-letter state.
+func BuryJob(ctx context.Context, jobID, worker, reason string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.BuryJob", attribute.String("job.id", jobID))
+	defer func() { tracing.End(span, err) }()
+
+	_, err = DB.ExecContext(ctx,
+		`UPDATE jobs SET status = 'dead', last_error = $3, locked_by = '', locked_until = NULL,
+			finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2`,
+		jobID, worker, reason,
+	)
+	return err
+}
+
+// RequeueDeadJob gives a dead job of userID a fresh set of attempts. It
+// returns sql.ErrNoRows if there is no such dead job. Requeueing a scan job
+// also puts a file that was marked scan_failed back to pending_scan, so the
+// retried job scans it instead of skipping a file that is no longer pending.
+func RequeueDeadJob(ctx context.Context, jobID, userID string) (_ *Job, err error) {
+	ctx, span := tracing.Start(ctx, "database.RequeueDeadJob", attribute.String("job.id", jobID))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var job Job
+	err = tx.QueryRowContext(ctx,
+		`UPDATE jobs SET status = 'queued', attempts = 0, run_at = NOW(), finished_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND status = 'dead'
+		RETURNING `+jobColumns,
+		jobID, userID,
+	).Scan(jobFields(&job)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileID string
+	if job.Kind == scanFileJob {
+		if fileID, err = resetFailedScan(ctx, tx, job.Payload); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if fileID != "" {
+		if err := cache.InvalidateCache(ctx, "file:"+fileID); err != nil {
+			slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+		}
+	}
+	return &job, nil
+}
+
+// scanFileJob is the kind of the worker's malware scan job.
+const scanFileJob = "scan_file"
+
+// resetFailedScan puts the file a scan job was enqueued for back to
+// pending_scan if its scan failed, returning its ID if it did. Clean and
+// infected verdicts are left alone.
+func resetFailedScan(ctx context.Context, tx *sql.Tx, payload json.RawMessage) (string, error) {
+	var target struct {
+		FileID  string `json:"file_id"`
+		Version int    `json:"version"`
+	}
+	if err := json.Unmarshal(payload, &target); err != nil {
+		return "", err
+	}
+
+	var version int
+	err := tx.QueryRowContext(ctx,
+		`UPDATE files SET scan_status = 'pending_scan', scan_signature = ''
+		WHERE id = $1 AND scan_status = 'scan_failed' AND ($2 = 0 OR version = $2)
+		RETURNING version`,
+		target.FileID, target.Version,
+	).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE file_versions SET scan_status = 'pending_scan'
+		WHERE file_id = $1 AND version = $2 AND scan_status = 'scan_failed'`,
+		target.FileID, version,
+	); err != nil {
+		return "", err
+	}
+	return target.FileID, nil
+}
+
+// GetJobForUser returns a job belonging to userID.
+func GetJobForUser(ctx context.Context, jobID, userID string) (_ *Job, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetJobForUser", attribute.String("job.id", jobID))
+	defer func() { tracing.End(span, err) }()
+
+	var job Job
+	err = DB.QueryRowContext(ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE id = $1 AND user_id = $2",
+		jobID, userID,
+	).Scan(jobFields(&job)...)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJobsByUserID returns the user's most recent jobs, optionally only
+// those in status.
+func GetJobsByUserID(ctx context.Context, userID, status string, limit int) (_ []Job, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetJobsByUserID", attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+jobColumns+` FROM jobs
+		WHERE user_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3`,
+		userID, status, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var job Job
+		if err := rows.Scan(jobFields(&job)...); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// DeleteFinishedJobs removes succeeded jobs finished before cutoff and
+// returns how many were removed. Dead jobs are kept for inspection.
+func DeleteFinishedJobs(ctx context.Context, cutoff time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "database.DeleteFinishedJobs")
+	defer func() { tracing.End(span, err) }()
+
+	result, err := DB.ExecContext(ctx,
+		"DELETE FROM jobs WHERE status = 'succeeded' AND finished_at < $1",
+		cutoff,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
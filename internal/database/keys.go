@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FileKey is a file's wrapped data key.
+type FileKey struct {
+	FileID     string
+	KeyID      string
+	WrappedKey []byte
+}
+
+// GetFileKey returns the wrapped data key of an encrypted file.
+func GetFileKey(ctx context.Context, fileID string) (_ *FileKey, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFileKey", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	key := FileKey{FileID: fileID}
+	err = DB.QueryRowContext(ctx,
+		"SELECT key_id, wrapped_key FROM files WHERE id = $1",
+		fileID,
+	).Scan(&key.KeyID, &key.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetFileKeysNotWrappedBy returns up to limit encrypted files whose data
+// key is wrapped by a master key other than keyID.
+func GetFileKeysNotWrappedBy(ctx context.Context, keyID string, limit int) (_ []FileKey, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFileKeysNotWrappedBy", attribute.String("key.id", keyID))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		`SELECT id, key_id, wrapped_key FROM files
+		WHERE key_id <> '' AND key_id <> $1
+		ORDER BY id
+		LIMIT $2`,
+		keyID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []FileKey
+	for rows.Next() {
+		var key FileKey
+		if err := rows.Scan(&key.FileID, &key.KeyID, &key.WrappedKey); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// UpdateFileKey replaces a file's wrapped data key, provided it is still
+// wrapped by oldKeyID. It reports whether the row was updated.
+func UpdateFileKey(ctx context.Context, fileID, oldKeyID, newKeyID string, wrappedKey []byte) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "database.UpdateFileKey", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	result, err := DB.ExecContext(ctx,
+		"UPDATE files SET key_id = $3, wrapped_key = $4 WHERE id = $1 AND key_id = $2",
+		fileID, oldKeyID, newKeyID, wrappedKey,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/lib/pq"
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FileFilter narrows GetFilesByUserID to files carrying all of Tags and
+// all of the Metadata pairs and, when Folder is set, directly in that
+// folder. The zero value matches every file.
+type FileFilter struct {
+	Tags     []string
+	Metadata Metadata
+	Folder   string
+}
+
+// TagCount is one of a user's tags and the number of files carrying it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+}
+
+// UpdateFileLabels changes a file's tags and metadata. update is called
+// with the current row locked and edits file.Tags and file.Metadata in
+// place; an error from it aborts the change and is returned as is.
+func UpdateFileLabels(ctx context.Context, fileID string, update func(file *File) error) (_ *File, err error) {
+	ctx, span := tracing.Start(ctx, "database.UpdateFileLabels", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var file File
+	if err := tx.QueryRowContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE id = $1 FOR UPDATE",
+		fileID,
+	).Scan(fileFields(&file)...); err != nil {
+		return nil, err
+	}
+	if err := update(&file); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE files SET metadata = $2 WHERE id = $1",
+		fileID, file.Metadata,
+	); err != nil {
+		return nil, err
+	}
+	if err := setFileTags(ctx, tx, file.UserID, fileID, file.Tags); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := cache.InvalidateCache(ctx, "file:"+fileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+	}
+	return &file, nil
+}
+
+// setFileTags makes tags the file's complete set of tags, creating any of
+// the owner's tags that do not exist yet.
+func setFileTags(ctx context.Context, tx *sql.Tx, userID, fileID string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO tags (user_id, name)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT (user_id, name) DO NOTHING`,
+		userID, pq.Array(tags),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM file_tags
+		WHERE file_id = $1
+		AND tag_id NOT IN (SELECT id FROM tags WHERE user_id = $2 AND name = ANY($3))`,
+		fileID, userID, pq.Array(tags),
+	); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO file_tags (file_id, tag_id)
+		SELECT $1, id FROM tags WHERE user_id = $2 AND name = ANY($3)
+		ON CONFLICT DO NOTHING`,
+		fileID, userID, pq.Array(tags),
+	)
+	return err
+}
+
+// fileFilterClause returns the SQL conditions for filter, numbering its
+// parameters after those already in args, and the extended args.
+func fileFilterClause(filter FileFilter, args []any) (string, []any) {
+	var clause string
+	if len(filter.Tags) > 0 {
+		args = append(args, pq.Array(filter.Tags), len(filter.Tags))
+		clause += fmt.Sprintf(`
+		AND files.id IN (
+			SELECT file_tags.file_id
+			FROM file_tags JOIN tags ON tags.id = file_tags.tag_id
+			WHERE tags.user_id = files.user_id AND tags.name = ANY($%d)
+			GROUP BY file_tags.file_id
+			HAVING count(*) = $%d
+		)`, len(args)-1, len(args))
+	}
+	if filter.Folder != "" {
+		args = append(args, filter.Folder)
+		clause += fmt.Sprintf("\n\t\tAND files.folder = $%d", len(args))
+	}
+	if len(filter.Metadata) > 0 {
+		data, _ := json.Marshal(filter.Metadata)
+		args = append(args, string(data))
+		clause += fmt.Sprintf("\n\t\tAND files.metadata @> $%d::jsonb", len(args))
+	}
+	return clause, args
+}
+
+// GetTags returns userID's tags that are on at least one file, most used
+// first, optionally only those starting with prefix.
+func GetTags(ctx context.Context, userID, prefix string, limit int) (_ []TagCount, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetTags", attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		`SELECT tags.name, count(*)
+		FROM tags JOIN file_tags ON file_tags.tag_id = tags.id
+		WHERE tags.user_id = $1 AND starts_with(tags.name, $2)
+		GROUP BY tags.name
+		ORDER BY count(*) DESC, tags.name
+		LIMIT $3`,
+		userID, prefix, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Files); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	// RunAbandoned marks a run whose instance died before finishing it.
+	RunAbandoned = "abandoned"
+)
+
+// ErrLockHeld is returned by AcquireLock when another instance holds the
+// lock.
+var ErrLockHeld = errors.New("lock is held by another instance")
+
+// AcquireLock takes the session-level Postgres advisory lock named name on
+// a dedicated connection, so that only one instance runs a task at a time.
+// Postgres releases the lock if the connection is lost, so a crashed
+// instance cannot keep it. Call release when the task is done.
+func AcquireLock(ctx context.Context, name string) (release func(), err error) {
+	ctx, span := tracing.Start(ctx, "database.AcquireLock", attribute.String("lock.name", name))
+	defer func() {
+		if err == ErrLockHeld {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrLockHeld
+	}
+
+	return func() {
+		// The task's context may already be cancelled; unlock regardless.
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name); err != nil {
+			slog.Warn("releasing advisory lock failed", "lock", name, "error", err)
+		}
+		conn.Close()
+	}, nil
+}
+
+const runColumns = "id, task, trigger, instance, dry_run, status, stats, error, started_at, finished_at"
+
+func runFields(run *MaintenanceRun) []any {
+	return []any{
+		&run.ID, &run.Task, &run.Trigger, &run.Instance, &run.DryRun,
+		&run.Status, &run.Stats, &run.Error, &run.StartedAt, &run.FinishedAt,
+	}
+}
+
+// StartMaintenanceRun records the start of a run. Callers must hold the
+// task's lock, so any run of the same task still marked running belongs to
+// an instance that died and is marked abandoned.
+func StartMaintenanceRun(ctx context.Context, run MaintenanceRun) (_ *MaintenanceRun, err error) {
+	ctx, span := tracing.Start(ctx, "database.StartMaintenanceRun", attribute.String("run.task", run.Task))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE maintenance_runs SET status = $2, finished_at = NOW()
+		WHERE task = $1 AND status = $3`,
+		run.Task, RunAbandoned, RunRunning,
+	); err != nil {
+		return nil, err
+	}
+
+	run.ID = uuid.New().String()
+	run.Status = RunRunning
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO maintenance_runs (id, task, trigger, instance, dry_run, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+runColumns,
+		run.ID, run.Task, run.Trigger, run.Instance, run.DryRun, run.Status,
+	).Scan(runFields(&run)...)
+	if err != nil {
+		return nil, err
+	}
+	return &run, tx.Commit()
+}
+
+// FinishMaintenanceRun records the outcome and counters of a run.
+func FinishMaintenanceRun(ctx context.Context, id, status string, stats []byte, runErr string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.FinishMaintenanceRun", attribute.String("run.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	if len(stats) == 0 {
+		stats = []byte("{}")
+	}
+	_, err = DB.ExecContext(ctx,
+		`UPDATE maintenance_runs
+		SET status = $2, stats = $3, error = $4, finished_at = NOW()
+		WHERE id = $1`,
+		id, status, string(stats), runErr,
+	)
+	return err
+}
+
+// GetMaintenanceRuns returns the most recent runs of task, newest first.
+// An empty task returns runs of every task.
+func GetMaintenanceRuns(ctx context.Context, task string, limit int) (_ []MaintenanceRun, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetMaintenanceRuns", attribute.String("run.task", task))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+runColumns+`
+		FROM maintenance_runs
+		WHERE $1 = '' OR task = $1
+		ORDER BY started_at DESC
+		LIMIT $2`,
+		task, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []MaintenanceRun{}
+	for rows.Next() {
+		var run MaintenanceRun
+		if err := rows.Scan(runFields(&run)...); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
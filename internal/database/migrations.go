@@ -0,0 +1,398 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+)
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations are applied in order and must never be edited once released;
+// add a new entry instead.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_users_and_files",
+		SQL: `
+		CREATE TABLE IF NOT EXISTS users (
+			id         UUID PRIMARY KEY,
+			email      TEXT NOT NULL UNIQUE,
+			name       TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS files (
+			id         UUID PRIMARY KEY,
+			user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name       TEXT NOT NULL,
+			path       TEXT NOT NULL,
+			size       BIGINT NOT NULL,
+			mime_type  TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			is_public  BOOLEAN NOT NULL DEFAULT false
+		);
+
+		CREATE INDEX IF NOT EXISTS files_user_id_idx ON files (user_id);`,
+	},
+	{
+		Version: 2,
+		Name:    "create_transfer_usage",
+		SQL: `
+		CREATE TABLE transfer_usage (
+			user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			period         DATE NOT NULL,
+			upload_bytes   BIGINT NOT NULL DEFAULT 0,
+			download_bytes BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, period)
+		);`,
+	},
+	{
+		Version: 3,
+		Name:    "create_upload_policies",
+		SQL: `
+		CREATE TABLE upload_policies (
+			user_id       UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			allowed_types TEXT[] NOT NULL DEFAULT '{}',
+			denied_types  TEXT[] NOT NULL DEFAULT '{}',
+			updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);`,
+	},
+	{
+		Version: 4,
+		Name:    "add_file_scan_status",
+		SQL: `
+		ALTER TABLE files
+			ADD COLUMN scan_status    TEXT NOT NULL DEFAULT 'clean',
+			ADD COLUMN scan_signature TEXT NOT NULL DEFAULT '',
+			ADD COLUMN scanned_at     TIMESTAMPTZ;
+
+		ALTER TABLE files ALTER COLUMN scan_status SET DEFAULT 'pending_scan';
+
+		CREATE INDEX files_pending_scan_idx ON files (created_at) WHERE scan_status = 'pending_scan';`,
+	},
+	{
+		Version: 5,
+		Name:    "add_file_encryption_keys",
+		SQL: `
+		ALTER TABLE files
+			ADD COLUMN key_id      TEXT NOT NULL DEFAULT '',
+			ADD COLUMN wrapped_key BYTEA;
+
+		CREATE INDEX files_key_id_idx ON files (key_id) WHERE key_id <> '';`,
+	},
+	{
+		Version: 6,
+		Name:    "create_jobs",
+		SQL: `
+		CREATE TABLE jobs (
+			id           UUID PRIMARY KEY,
+			kind         TEXT NOT NULL,
+			payload      JSONB NOT NULL DEFAULT '{}',
+			user_id      UUID REFERENCES users(id) ON DELETE CASCADE,
+			status       TEXT NOT NULL DEFAULT 'queued',
+			attempts     INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL,
+			run_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			locked_by    TEXT NOT NULL DEFAULT '',
+			locked_until TIMESTAMPTZ,
+			last_error   TEXT NOT NULL DEFAULT '',
+			created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at  TIMESTAMPTZ
+		);
+
+		CREATE INDEX jobs_runnable_idx ON jobs (run_at) WHERE status IN ('queued', 'running');
+		CREATE INDEX jobs_user_id_idx ON jobs (user_id, created_at);
+
+		-- Files left pending by the in-memory scan queue get a durable job.
+		INSERT INTO jobs (id, kind, payload, user_id, max_attempts)
+		SELECT gen_random_uuid(), 'scan_file', jsonb_build_object('file_id', id), user_id, 5
+		FROM files WHERE scan_status = 'pending_scan';`,
+	},
+	{
+		Version: 7,
+		Name:    "create_uploads",
+		SQL: `
+		CREATE TABLE uploads (
+			id         UUID PRIMARY KEY,
+			user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			file_id    UUID REFERENCES files(id) ON DELETE SET NULL,
+			status     TEXT NOT NULL,
+			error      TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE INDEX uploads_file_id_idx ON uploads (file_id);
+		CREATE INDEX uploads_receiving_idx ON uploads (updated_at) WHERE status = 'receiving';`,
+	},
+	{
+		Version: 8,
+		Name:    "create_maintenance_runs",
+		SQL: `
+		CREATE TABLE maintenance_runs (
+			id          UUID PRIMARY KEY,
+			task        TEXT NOT NULL,
+			trigger     TEXT NOT NULL,
+			instance    TEXT NOT NULL,
+			dry_run     BOOLEAN NOT NULL DEFAULT false,
+			status      TEXT NOT NULL,
+			stats       JSONB NOT NULL DEFAULT '{}',
+			error       TEXT NOT NULL DEFAULT '',
+			started_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMPTZ
+		);
+
+		CREATE INDEX maintenance_runs_task_idx ON maintenance_runs (task, started_at DESC);
+		CREATE INDEX files_expiry_idx ON files (created_at, id) WHERE is_public = false;`,
+	},
+	{
+		Version: 9,
+		Name:    "add_file_content_missing",
+		SQL: `
+		ALTER TABLE files ADD COLUMN content_missing_at TIMESTAMPTZ;
+		CREATE INDEX files_path_idx ON files (path);
+		CREATE INDEX files_created_idx ON files (created_at, id);`,
+	},
+	{
+		Version: 10,
+		Name:    "create_thumbnails",
+		SQL: `
+		CREATE TABLE thumbnails (
+			file_id    UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+			size       TEXT NOT NULL,
+			path       TEXT NOT NULL,
+			mime_type  TEXT NOT NULL,
+			width      INTEGER NOT NULL,
+			height     INTEGER NOT NULL,
+			bytes      BIGINT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (file_id, size)
+		);
+
+		CREATE INDEX thumbnails_path_idx ON thumbnails (path);`,
+	},
+	{
+		Version: 11,
+		Name:    "create_file_search",
+		SQL: `
+		CREATE FUNCTION file_name_vector(name TEXT) RETURNS tsvector
+		LANGUAGE sql IMMUTABLE AS
+		$$ SELECT setweight(to_tsvector('simple', regexp_replace(name, '[._-]+', ' ', 'g')), 'A') $$;
+
+		CREATE TABLE file_texts (
+			file_id      UUID PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+			content      TEXT NOT NULL,
+			extracted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		ALTER TABLE files ADD COLUMN search_vector TSVECTOR;
+		UPDATE files SET search_vector = file_name_vector(name);
+		CREATE INDEX files_search_idx ON files USING GIN (search_vector);
+
+		-- Index the text of documents uploaded before search existed.
+		INSERT INTO jobs (id, kind, payload, user_id, max_attempts)
+		SELECT gen_random_uuid(), 'extract_text', jsonb_build_object('file_id', id), user_id, 5
+		FROM files
+		WHERE scan_status = 'clean'
+		AND split_part(mime_type, ';', 1) IN ('text/plain', 'text/markdown', 'text/x-markdown',
+			'text/csv', 'application/json', 'text/html', 'application/pdf');`,
+	},
+	{
+		Version: 12,
+		Name:    "create_tags_and_metadata",
+		SQL: `
+		CREATE TABLE tags (
+			id         BIGSERIAL PRIMARY KEY,
+			user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name       TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, name)
+		);
+
+		CREATE TABLE file_tags (
+			file_id UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+			tag_id  BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (file_id, tag_id)
+		);
+
+		CREATE INDEX file_tags_tag_id_idx ON file_tags (tag_id);
+
+		ALTER TABLE files ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}';
+		CREATE INDEX files_metadata_idx ON files USING GIN (metadata jsonb_path_ops);`,
+	},
+	{
+		Version: 13,
+		Name:    "create_file_versions",
+		SQL: `
+		ALTER TABLE files
+			ADD COLUMN version      INTEGER NOT NULL DEFAULT 1,
+			ADD COLUMN last_version INTEGER NOT NULL DEFAULT 1,
+			ADD COLUMN sha256       TEXT NOT NULL DEFAULT '';
+
+		CREATE TABLE file_versions (
+			file_id     UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+			version     INTEGER NOT NULL,
+			path        TEXT NOT NULL,
+			size        BIGINT NOT NULL,
+			mime_type   TEXT NOT NULL,
+			sha256      TEXT NOT NULL DEFAULT '',
+			uploaded_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			scan_status TEXT NOT NULL,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (file_id, version)
+		);
+
+		CREATE INDEX file_versions_path_idx ON file_versions (path);
+
+		INSERT INTO file_versions (file_id, version, path, size, mime_type, uploaded_by, scan_status, created_at)
+		SELECT id, 1, path, size, mime_type, user_id, scan_status, created_at FROM files;`,
+	},
+	{
+		Version: 14,
+		Name:    "add_file_folders",
+		SQL: `
+		ALTER TABLE files ADD COLUMN folder TEXT NOT NULL DEFAULT '/';
+		CREATE INDEX files_folder_idx ON files (user_id, folder);`,
+	},
+	{
+		Version: 15,
+		Name:    "add_file_sources",
+		SQL: `
+		ALTER TABLE files ADD COLUMN source_id UUID REFERENCES files(id) ON DELETE SET NULL;
+		CREATE INDEX files_source_idx ON files (source_id) WHERE source_id IS NOT NULL;`,
+	},
+	{
+		Version: 16,
+		Name:    "create_file_slots",
+		SQL: `
+		CREATE TABLE file_slots (
+			file_id    UUID PRIMARY KEY,
+			user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name       TEXT NOT NULL,
+			folder     TEXT NOT NULL DEFAULT '/',
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE INDEX file_slots_expires_at_idx ON file_slots (expires_at);`,
+	},
+	{
+		Version: 17,
+		Name:    "add_direct_upload_slots",
+		SQL: `
+		ALTER TABLE file_slots
+			ADD COLUMN object_key   TEXT NOT NULL DEFAULT '',
+			ADD COLUMN multipart_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN size         BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN sha256       TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 18,
+		Name:    "add_file_corrupt_at",
+		SQL: `
+		ALTER TABLE files ADD COLUMN corrupt_at TIMESTAMPTZ;`,
+	},
+	{
+		Version: 19,
+		Name:    "index_stored_names",
+		SQL: `
+		CREATE INDEX files_stored_name_idx ON files (regexp_replace(path, '^.*/', ''));
+		CREATE INDEX file_versions_stored_name_idx ON file_versions (regexp_replace(path, '^.*/', ''));
+		CREATE INDEX thumbnails_stored_name_idx ON thumbnails (regexp_replace(path, '^.*/', ''));`,
+	},
+}
+
+// Migrate applies every migration that has not been recorded in
+// schema_migrations yet.
+func Migrate(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "database.Migrate")
+	defer func() { tracing.End(span, err) }()
+
+	_, err = DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		tx, err := DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+			m.Version, m.Name,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "applied migration", "version", m.Version, "name", m.Name)
+	}
+
+	return nil
+}
+
+// PendingMigrations returns the number of known migrations that have not
+// been applied to the connected database.
+func PendingMigrations(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "database.PendingMigrations")
+	defer func() { tracing.End(span, err) }()
+
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
@@ -1,6 +1,9 @@
 package database
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -20,4 +23,170 @@ type File struct {
 	MimeType  string    `json:"mime_type" db:"mime_type"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	IsPublic  bool      `json:"is_public" db:"is_public"`
+	// ScanStatus is one of the scan.Status* values; ScanSignature names
+	// the malware found when the status is infected.
+	ScanStatus    string `json:"scan_status" db:"scan_status"`
+	ScanSignature string `json:"scan_signature,omitempty" db:"scan_signature"`
+	// KeyID names the master key that wraps the file's data key; empty
+	// for files stored in plaintext. WrappedKey is never serialized, so
+	// metadata read from the cache has to fetch it with GetFileKey.
+	KeyID      string `json:"key_id,omitempty" db:"key_id"`
+	WrappedKey []byte `json:"-" db:"wrapped_key"`
+	// ContentMissingAt is set when reconciliation found the stored
+	// content gone.
+	ContentMissingAt *time.Time `json:"content_missing_at,omitempty" db:"content_missing_at"`
+	// CorruptAt is set when a scrub found the stored content no longer
+	// matches its SHA256.
+	CorruptAt *time.Time `json:"corrupt_at,omitempty" db:"corrupt_at"`
+	// Thumbnails names the thumbnail sizes generated so far.
+	Thumbnails []string `json:"thumbnails,omitempty" db:"-"`
+	// Tags are the owner's labels for the file; Metadata holds arbitrary
+	// key/value pairs.
+	Tags     []string `json:"tags" db:"-"`
+	Metadata Metadata `json:"metadata" db:"metadata"`
+	// Version is the number of the current version; SHA256 is the hex
+	// digest of its content, empty for content stored before hashing.
+	Version int    `json:"version" db:"version"`
+	SHA256  string `json:"sha256,omitempty" db:"sha256"`
+	// Folder is the file's place in its owner's folder tree, "/" for the
+	// root.
+	Folder string `json:"folder" db:"folder"`
+	// SourceID is the archive the file was extracted from.
+	SourceID *string `json:"source_id,omitempty" db:"source_id"`
+}
+
+// FileVersion is one stored revision of a file's content.
+type FileVersion struct {
+	FileID     string    `json:"file_id" db:"file_id"`
+	Version    int       `json:"version" db:"version"`
+	Path       string    `json:"-" db:"path"`
+	Size       int64     `json:"size" db:"size"`
+	MimeType   string    `json:"mime_type" db:"mime_type"`
+	SHA256     string    `json:"sha256,omitempty" db:"sha256"`
+	UploadedBy *string   `json:"uploaded_by" db:"uploaded_by"`
+	ScanStatus string    `json:"scan_status" db:"scan_status"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	Current    bool      `json:"current" db:"-"`
+}
+
+// Metadata is user-defined key/value data stored as a JSONB object.
+type Metadata map[string]string
+
+// Value implements driver.Valuer.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner.
+func (m *Metadata) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*m = Metadata{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", src)
+	}
+	*m = Metadata{}
+	return json.Unmarshal(data, m)
+}
+
+// Thumbnail is a stored preview of an image file. Its content is
+// encrypted with a key derived from the file's data key.
+type Thumbnail struct {
+	FileID    string    `json:"file_id" db:"file_id"`
+	Size      string    `json:"size" db:"size"`
+	Path      string    `json:"-" db:"path"`
+	MimeType  string    `json:"mime_type" db:"mime_type"`
+	Width     int       `json:"width" db:"width"`
+	Height    int       `json:"height" db:"height"`
+	Bytes     int64     `json:"bytes" db:"bytes"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TransferUsage is the bytes a user moved during one calendar month (UTC).
+type TransferUsage struct {
+	UserID        string    `json:"-" db:"user_id"`
+	Period        time.Time `json:"period" db:"period"`
+	UploadBytes   int64     `json:"upload_bytes" db:"upload_bytes"`
+	DownloadBytes int64     `json:"download_bytes" db:"download_bytes"`
+}
+
+// UploadPolicy narrows the file types a user may upload, on top of the
+// server-wide policy.
+type UploadPolicy struct {
+	UserID       string    `json:"-" db:"user_id"`
+	AllowedTypes []string  `json:"allowed_types" db:"allowed_types"`
+	DeniedTypes  []string  `json:"denied_types" db:"denied_types"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Job is a unit of background work in the durable queue. Status moves from
+// queued to running and then succeeded, back to queued for a retry, or to
+// dead once MaxAttempts is exhausted.
+type Job struct {
+	ID          string          `json:"id" db:"id"`
+	Kind        string          `json:"kind" db:"kind"`
+	Payload     json.RawMessage `json:"payload" db:"payload"`
+	UserID      string          `json:"-" db:"user_id"`
+	Status      string          `json:"status" db:"status"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	MaxAttempts int             `json:"max_attempts" db:"max_attempts"`
+	RunAt       time.Time       `json:"run_at" db:"run_at"`
+	LastError   string          `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" db:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// MaintenanceRun records one run of a periodic maintenance task such as
+// cleanup. Stats holds task-specific counters.
+type MaintenanceRun struct {
+	ID         string          `json:"id" db:"id"`
+	Task       string          `json:"task" db:"task"`
+	Trigger    string          `json:"trigger" db:"trigger"`
+	Instance   string          `json:"instance" db:"instance"`
+	DryRun     bool            `json:"dry_run" db:"dry_run"`
+	Status     string          `json:"status" db:"status"`
+	Stats      json.RawMessage `json:"stats" db:"stats"`
+	Error      string          `json:"error,omitempty" db:"error"`
+	StartedAt  time.Time       `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// FileSlot reserves a file ID for content uploaded later through a
+// presigned URL. Direct uploads to the object store also record the
+// staged object's key, its multipart upload if any, and the size and
+// SHA-256 the client declared.
+type FileSlot struct {
+	FileID      string    `json:"file_id" db:"file_id"`
+	UserID      string    `json:"-" db:"user_id"`
+	Name        string    `json:"name" db:"name"`
+	Folder      string    `json:"folder" db:"folder"`
+	ObjectKey   string    `json:"-" db:"object_key"`
+	MultipartID string    `json:"-" db:"multipart_id"`
+	Size        int64     `json:"size,omitempty" db:"size"`
+	SHA256      string    `json:"sha256,omitempty" db:"sha256"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Upload tracks one POST /upload request from receipt of the body until
+// its file has been stored and processed.
+type Upload struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"-" db:"user_id"`
+	FileID    string    `json:"file_id,omitempty" db:"file_id"`
+	Status    string    `json:"status" db:"status"`
+	Error     string    `json:"error,omitempty" db:"error"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
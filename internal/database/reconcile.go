@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/lib/pq"
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GetFilesPage returns up to limit files of every user, ordered by creation
+// time and ID, starting after cursor.
+func GetFilesPage(ctx context.Context, cursor FileCursor, limit int) (_ []File, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFilesPage")
+	defer func() { tracing.End(span, err) }()
+
+	if cursor.ID == "" {
+		cursor.ID = "00000000-0000-0000-0000-000000000000"
+	}
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+fileColumns+`
+		FROM files
+		WHERE (created_at, id) > ($1, $2::uuid)
+		ORDER BY created_at, id
+		LIMIT $3`,
+		cursor.CreatedAt, cursor.ID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var file File
+		if err := rows.Scan(fileFields(&file)...); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// GetKnownNames reports which of the stored object names, the base names
+// of stored paths, are referenced by a file, file version or thumbnail row.
+// Names are compared rather than whole paths so rows written while the
+// storage directory was spelled differently, relative or absolute, still
+// match their blobs.
+func GetKnownNames(ctx context.Context, names []string) (_ map[string]bool, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetKnownNames", attribute.Int("names.count", len(names)))
+	defer func() { tracing.End(span, err) }()
+
+	// The expression matches the stored name indexes of migration 19
+	rows, err := DB.QueryContext(ctx,
+		`SELECT regexp_replace(path, '^.*/', '') FROM files WHERE regexp_replace(path, '^.*/', '') = ANY($1)
+		UNION SELECT regexp_replace(path, '^.*/', '') FROM file_versions WHERE regexp_replace(path, '^.*/', '') = ANY($1)
+		UNION SELECT regexp_replace(path, '^.*/', '') FROM thumbnails WHERE regexp_replace(path, '^.*/', '') = ANY($1)`,
+		pq.Array(names),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[string]bool, len(names))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		known[name] = true
+	}
+	return known, rows.Err()
+}
+
+// GetOlderVersionsPage returns up to limit versions that are not the
+// current version of their file, ordered by file ID and version, starting
+// after cursor. The current version's content is the file row's.
+func GetOlderVersionsPage(ctx context.Context, cursor VersionCursor, limit int) (_ []FileVersion, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetOlderVersionsPage")
+	defer func() { tracing.End(span, err) }()
+
+	if cursor.FileID == "" {
+		cursor.FileID = "00000000-0000-0000-0000-000000000000"
+	}
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+versionColumns+`
+		FROM file_versions JOIN files ON files.id = file_versions.file_id
+		WHERE file_versions.version <> files.version
+		AND (file_versions.file_id, file_versions.version) > ($1::uuid, $2)
+		ORDER BY file_versions.file_id, file_versions.version
+		LIMIT $3`,
+		cursor.FileID, cursor.Version, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []FileVersion
+	for rows.Next() {
+		var v FileVersion
+		if err := rows.Scan(versionFields(&v)...); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// SetContentMissing marks a file's stored content as missing, or clears
+// the mark when it has reappeared. It reports whether the row changed.
+func SetContentMissing(ctx context.Context, fileID string, missing bool) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "database.SetContentMissing",
+		attribute.String("file.id", fileID),
+		attribute.Bool("file.content_missing", missing),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	result, err := DB.ExecContext(ctx,
+		`UPDATE files
+		SET content_missing_at = CASE WHEN $2 THEN NOW() END
+		WHERE id = $1 AND (content_missing_at IS NULL) = $2`,
+		fileID, missing,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if err := cache.InvalidateCache(ctx, "file:"+fileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SetScanResult records the scan verdict of a file version and its storage
+// path, which changes when an infected file is quarantined. The file itself
+// is only updated while that version is still current.
+func SetScanResult(ctx context.Context, fileID string, version int, status, signature, path string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.SetScanResult",
+		attribute.String("file.id", fileID),
+		attribute.Int("file.version", version),
+		attribute.String("scan.status", status),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE files SET scan_status = $3, scan_signature = $4, path = $5, scanned_at = NOW()
+		WHERE id = $1 AND version = $2`,
+		fileID, version, status, signature, path,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE file_versions SET scan_status = $3, path = $4 WHERE file_id = $1 AND version = $2",
+		fileID, version, status, path,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Cached metadata would otherwise keep the file blocked, or unblocked.
+	if err := cache.InvalidateCache(ctx, "file:"+fileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SetContentCorrupt marks version of a file as corrupt, or clears the mark
+// when its content verifies again. Nothing changes if the file has moved
+// on to another version. It reports whether the row changed.
+func SetContentCorrupt(ctx context.Context, fileID string, version int, corrupt bool) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "database.SetContentCorrupt",
+		attribute.String("file.id", fileID),
+		attribute.Bool("file.corrupt", corrupt),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	result, err := DB.ExecContext(ctx,
+		`UPDATE files
+		SET corrupt_at = CASE WHEN $3 THEN NOW() END
+		WHERE id = $1 AND version = $2 AND (corrupt_at IS NULL) = $3`,
+		fileID, version, corrupt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if err := cache.InvalidateCache(ctx, "file:"+fileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+	}
+	return true, nil
+}
+
+// SetFileChecksum records the SHA-256 of a version stored before uploads
+// were hashed. Versions that already have one are left alone.
+func SetFileChecksum(ctx context.Context, fileID string, version int, sha256 string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.SetFileChecksum", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE files SET sha256 = $3 WHERE id = $1 AND version = $2 AND sha256 = ''",
+		fileID, version, sha256,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE file_versions SET sha256 = $3 WHERE file_id = $1 AND version = $2 AND sha256 = ''",
+		fileID, version, sha256,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := cache.InvalidateCache(ctx, "file:"+fileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+	}
+	return nil
+}
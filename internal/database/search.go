@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Highlight markers wrapped around matched words by SearchFiles. They are
+// private-use characters so callers can escape the text before replacing
+// them with markup.
+const (
+	HighlightStart = "\ue000"
+	HighlightStop  = "\ue001"
+)
+
+// SearchResult is a file matching a search, with the matches highlighted
+// in its name and in a snippet of its text.
+type SearchResult struct {
+	File          File    `json:"file"`
+	Rank          float64 `json:"rank"`
+	NameHighlight string  `json:"name_highlight"`
+	Snippet       string  `json:"snippet,omitempty"`
+}
+
+// SaveFileText stores the text extracted from a file and indexes it for
+// search along with the file's name, which ranks higher.
+func SaveFileText(ctx context.Context, fileID, content string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.SaveFileText",
+		attribute.String("file.id", fileID),
+		attribute.Int("text.bytes", len(content)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO file_texts (file_id, content) VALUES ($1, $2)
+		ON CONFLICT (file_id) DO UPDATE SET content = EXCLUDED.content, extracted_at = NOW()`,
+		fileID, content,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE files
+		SET search_vector = file_name_vector(name) || setweight(to_tsvector('english', $2), 'B')
+		WHERE id = $1`,
+		fileID, content,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SearchFiles finds files matching query, in web search syntax ("quoted
+// phrases", OR, -excluded), among userID's own files and other users'
+// public files that passed their malware scan. Results are ordered by
+// relevance.
+func SearchFiles(ctx context.Context, userID, query string, limit, offset int) (_ []SearchResult, err error) {
+	ctx, span := tracing.Start(ctx, "database.SearchFiles", attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	// Names are indexed without stemming and text with English stemming,
+	// so the query is parsed both ways. Highlighting only runs on the page
+	// of results being returned.
+	rows, err := DB.QueryContext(ctx,
+		`WITH q AS (
+			SELECT websearch_to_tsquery('english', $1) || websearch_to_tsquery('simple', $1) AS query
+		), hits AS (
+			SELECT files.id, ts_rank_cd(files.search_vector, q.query) AS rank
+			FROM files, q
+			WHERE files.search_vector @@ q.query
+			AND (files.user_id = $2 OR (files.is_public AND files.scan_status = 'clean'))
+			ORDER BY rank DESC, files.created_at DESC, files.id
+			LIMIT $3 OFFSET $4
+		)
+		SELECT `+fileColumns+`, hits.rank,
+			ts_headline('simple', files.name, q.query, $5),
+			COALESCE(ts_headline('english', file_texts.content, q.query, $6), '')
+		FROM hits
+		JOIN files ON files.id = hits.id
+		CROSS JOIN q
+		LEFT JOIN file_texts ON file_texts.file_id = files.id
+		ORDER BY hits.rank DESC, files.created_at DESC, files.id`,
+		query, userID, limit, offset,
+		"StartSel="+HighlightStart+", StopSel="+HighlightStop+", HighlightAll=true",
+		"StartSel="+HighlightStart+", StopSel="+HighlightStop+", MaxFragments=3, MaxWords=25, MinWords=8",
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "searching files failed", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		dest := append(fileFields(&result.File), &result.Rank, &result.NameHighlight, &result.Snippet)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// slotColumns lists the file_slots columns in the order slotFields scans
+// them.
+const slotColumns = "file_id, user_id, name, folder, object_key, multipart_id, size, sha256, expires_at, created_at"
+
+// CreateFileSlot reserves slot.FileID for userID until slot.ExpiresAt.
+func CreateFileSlot(ctx context.Context, slot FileSlot) (_ *FileSlot, err error) {
+	ctx, span := tracing.Start(ctx, "database.CreateFileSlot", attribute.String("file.id", slot.FileID))
+	defer func() { tracing.End(span, err) }()
+
+	err = DB.QueryRowContext(ctx,
+		`INSERT INTO file_slots (file_id, user_id, name, folder, object_key, multipart_id, size, sha256, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING created_at`,
+		slot.FileID, slot.UserID, slot.Name, slot.Folder,
+		slot.ObjectKey, slot.MultipartID, slot.Size, slot.SHA256, slot.ExpiresAt,
+	).Scan(&slot.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &slot, nil
+}
+
+// ClaimFileSlot removes and returns userID's unexpired slot for fileID, so
+// each slot is used at most once. direct selects slots for direct uploads
+// to the object store rather than for uploads through the API. It returns
+// sql.ErrNoRows if there is no such slot.
+func ClaimFileSlot(ctx context.Context, fileID, userID string, direct bool) (_ *FileSlot, err error) {
+	ctx, span := tracing.Start(ctx, "database.ClaimFileSlot", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	var slot FileSlot
+	err = DB.QueryRowContext(ctx,
+		`DELETE FROM file_slots
+		WHERE file_id = $1 AND user_id = $2 AND expires_at > NOW() AND (object_key <> '') = $3
+		RETURNING `+slotColumns,
+		fileID, userID, direct,
+	).Scan(slotFields(&slot)...)
+	if err != nil {
+		return nil, err
+	}
+	return &slot, nil
+}
+
+// DeleteExpiredFileSlots removes slots that were never used before they
+// expired and returns them, so their staged objects can be removed too.
+func DeleteExpiredFileSlots(ctx context.Context) (_ []FileSlot, err error) {
+	ctx, span := tracing.Start(ctx, "database.DeleteExpiredFileSlots")
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx, "DELETE FROM file_slots WHERE expires_at <= NOW() RETURNING "+slotColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []FileSlot
+	for rows.Next() {
+		var slot FileSlot
+		if err := rows.Scan(slotFields(&slot)...); err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	return slots, rows.Err()
+}
+
+func slotFields(slot *FileSlot) []any {
+	return []any{
+		&slot.FileID, &slot.UserID, &slot.Name, &slot.Folder,
+		&slot.ObjectKey, &slot.MultipartID, &slot.Size, &slot.SHA256,
+		&slot.ExpiresAt, &slot.CreatedAt,
+	}
+}
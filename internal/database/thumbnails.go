@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SaveThumbnail records a generated thumbnail, replacing an earlier one of
+// the same size.
+func SaveThumbnail(ctx context.Context, thumb Thumbnail) (err error) {
+	ctx, span := tracing.Start(ctx, "database.SaveThumbnail",
+		attribute.String("file.id", thumb.FileID),
+		attribute.String("thumbnail.size", thumb.Size),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = DB.ExecContext(ctx,
+		`INSERT INTO thumbnails (file_id, size, path, mime_type, width, height, bytes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (file_id, size) DO UPDATE
+		SET path = EXCLUDED.path, mime_type = EXCLUDED.mime_type, width = EXCLUDED.width,
+			height = EXCLUDED.height, bytes = EXCLUDED.bytes, created_at = NOW()`,
+		thumb.FileID, thumb.Size, thumb.Path, thumb.MimeType, thumb.Width, thumb.Height, thumb.Bytes,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Cached file metadata lists the available thumbnails.
+	if err := cache.InvalidateCache(ctx, "file:"+thumb.FileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+	}
+	return nil
+}
+
+// GetThumbnail returns a file's thumbnail of the given size, or
+// sql.ErrNoRows if it has not been generated.
+func GetThumbnail(ctx context.Context, fileID, size string) (_ *Thumbnail, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetThumbnail",
+		attribute.String("file.id", fileID),
+		attribute.String("thumbnail.size", size),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	thumb := Thumbnail{FileID: fileID, Size: size}
+	err = DB.QueryRowContext(ctx,
+		`SELECT path, mime_type, width, height, bytes, created_at
+		FROM thumbnails WHERE file_id = $1 AND size = $2`,
+		fileID, size,
+	).Scan(&thumb.Path, &thumb.MimeType, &thumb.Width, &thumb.Height, &thumb.Bytes, &thumb.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &thumb, nil
+}
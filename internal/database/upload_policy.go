@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GetUploadPolicy returns the user's upload policy. Users without one get
+// an empty policy, which permits everything the server-wide policy does.
+func GetUploadPolicy(ctx context.Context, userID string) (_ *UploadPolicy, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetUploadPolicy", attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	policy := UploadPolicy{UserID: userID, AllowedTypes: []string{}, DeniedTypes: []string{}}
+	err = DB.QueryRowContext(ctx,
+		"SELECT allowed_types, denied_types, updated_at FROM upload_policies WHERE user_id = $1",
+		userID,
+	).Scan(pq.Array(&policy.AllowedTypes), pq.Array(&policy.DeniedTypes), &policy.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetUploadPolicy creates or replaces the user's upload policy.
+func SetUploadPolicy(ctx context.Context, policy UploadPolicy) (_ *UploadPolicy, err error) {
+	ctx, span := tracing.Start(ctx, "database.SetUploadPolicy", attribute.String("user.id", policy.UserID))
+	defer func() { tracing.End(span, err) }()
+
+	err = DB.QueryRowContext(ctx,
+		`INSERT INTO upload_policies (user_id, allowed_types, denied_types)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			allowed_types = EXCLUDED.allowed_types,
+			denied_types = EXCLUDED.denied_types,
+			updated_at = NOW()
+		RETURNING updated_at`,
+		policy.UserID, pq.Array(policy.AllowedTypes), pq.Array(policy.DeniedTypes),
+	).Scan(&policy.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
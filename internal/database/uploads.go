@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Upload statuses, in the order an upload moves through them. Complete
+// and failed are final.
+const (
+	UploadReceiving  = "receiving"
+	UploadStored     = "stored"
+	UploadProcessing = "processing"
+	UploadComplete   = "complete"
+	UploadFailed     = "failed"
+)
+
+// UploadFinished reports whether status is final.
+func UploadFinished(status string) bool {
+	return status == UploadComplete || status == UploadFailed
+}
+
+// UploadChannel is the pub/sub channel announcing status changes of an
+// upload.
+func UploadChannel(uploadID string) string {
+	return "uploads:" + uploadID
+}
+
+// uploadAdvances restricts an update to uploads that are not final and
+// are behind the new status ($2), so a late writer cannot move an upload
+// backwards, for example from processing back to stored.
+const uploadAdvances = `status NOT IN ('complete', 'failed')
+	AND array_position(ARRAY['receiving', 'stored', 'processing', 'complete', 'failed'], status)
+		< array_position(ARRAY['receiving', 'stored', 'processing', 'complete', 'failed'], $2::text)`
+
+const uploadColumns = "id, user_id, COALESCE(file_id::text, ''), status, error, created_at, updated_at"
+
+func uploadFields(u *Upload) []any {
+	return []any{&u.ID, &u.UserID, &u.FileID, &u.Status, &u.Error, &u.CreatedAt, &u.UpdatedAt}
+}
+
+// CreateUpload records a new upload in the receiving state.
+func CreateUpload(ctx context.Context, upload Upload) (_ *Upload, err error) {
+	ctx, span := tracing.Start(ctx, "database.CreateUpload", attribute.String("upload.id", upload.ID))
+	defer func() { tracing.End(span, err) }()
+
+	upload.Status = UploadReceiving
+	err = DB.QueryRowContext(ctx,
+		`INSERT INTO uploads (id, user_id, status) VALUES ($1, $2, $3)
+		RETURNING created_at, updated_at`,
+		upload.ID, upload.UserID, upload.Status,
+	).Scan(&upload.CreatedAt, &upload.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// SetUploadStatus moves an upload forward to status, recording reason for
+// failures and the stored file once known. Final uploads are not changed.
+func SetUploadStatus(ctx context.Context, uploadID, status, reason, fileID string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.SetUploadStatus",
+		attribute.String("upload.id", uploadID),
+		attribute.String("upload.status", status),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = DB.ExecContext(ctx,
+		`UPDATE uploads SET status = $2, error = $3, file_id = COALESCE(NULLIF($4, '')::uuid, file_id), updated_at = NOW()
+		WHERE id = $1 AND `+uploadAdvances,
+		uploadID, status, reason, fileID,
+	)
+	if err != nil {
+		return err
+	}
+	publishUploadStatus(ctx, uploadID, status)
+	return nil
+}
+
+// SetUploadStatusByFile moves the upload that stored fileID to status.
+func SetUploadStatusByFile(ctx context.Context, fileID, status, reason string) (err error) {
+	ctx, span := tracing.Start(ctx, "database.SetUploadStatusByFile",
+		attribute.String("file.id", fileID),
+		attribute.String("upload.status", status),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		`UPDATE uploads SET status = $2, error = $3, updated_at = NOW()
+		WHERE file_id = $1 AND `+uploadAdvances+`
+		RETURNING id`,
+		fileID, status, reason,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		publishUploadStatus(ctx, id, status)
+	}
+	return nil
+}
+
+// publishUploadStatus wakes long-polling clients. Pollers re-read the
+// upload, so a lost message only delays them until their timeout.
+func publishUploadStatus(ctx context.Context, uploadID, status string) {
+	if err := cache.Publish(ctx, UploadChannel(uploadID), status); err != nil {
+		slog.WarnContext(ctx, "publishing upload status failed", "upload_id", uploadID, "error", err)
+	}
+}
+
+// GetUploadForUser returns one of the user's uploads.
+func GetUploadForUser(ctx context.Context, uploadID, userID string) (_ *Upload, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetUploadForUser", attribute.String("upload.id", uploadID))
+	defer func() { tracing.End(span, err) }()
+
+	var upload Upload
+	err = DB.QueryRowContext(ctx,
+		"SELECT "+uploadColumns+" FROM uploads WHERE id = $1 AND user_id = $2",
+		uploadID, userID,
+	).Scan(uploadFields(&upload)...)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// FailStaleUploads marks uploads still receiving since before cutoff as
+// failed; their request died without recording an outcome.
+func FailStaleUploads(ctx context.Context, cutoff time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "database.FailStaleUploads")
+	defer func() { tracing.End(span, err) }()
+
+	result, err := DB.ExecContext(ctx,
+		`UPDATE uploads SET status = 'failed', error = 'upload interrupted', updated_at = NOW()
+		WHERE status = 'receiving' AND updated_at < $1`,
+		cutoff,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
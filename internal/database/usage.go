@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// monthStart returns the first day of t's month in UTC.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// RecordTransfer adds uploaded and downloaded bytes to the user's total for
+// the current month.
+func RecordTransfer(ctx context.Context, userID string, uploadBytes, downloadBytes int64) (err error) {
+	ctx, span := tracing.Start(ctx, "database.RecordTransfer",
+		attribute.String("user.id", userID),
+		attribute.Int64("transfer.upload_bytes", uploadBytes),
+		attribute.Int64("transfer.download_bytes", downloadBytes),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = DB.ExecContext(ctx,
+		`INSERT INTO transfer_usage (user_id, period, upload_bytes, download_bytes)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, period) DO UPDATE SET
+			upload_bytes = transfer_usage.upload_bytes + EXCLUDED.upload_bytes,
+			download_bytes = transfer_usage.download_bytes + EXCLUDED.download_bytes`,
+		userID, monthStart(time.Now()), uploadBytes, downloadBytes,
+	)
+	return err
+}
+
+// GetTransferUsage returns the user's monthly totals for the last months
+// months, newest first. Months without transfers are omitted.
+func GetTransferUsage(ctx context.Context, userID string, months int) (_ []TransferUsage, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetTransferUsage", attribute.String("user.id", userID))
+	defer func() { tracing.End(span, err) }()
+
+	since := monthStart(time.Now()).AddDate(0, -(months - 1), 0)
+	rows, err := DB.QueryContext(ctx,
+		`SELECT user_id, period, upload_bytes, download_bytes
+		FROM transfer_usage
+		WHERE user_id = $1 AND period >= $2
+		ORDER BY period DESC`,
+		userID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []TransferUsage{}
+	for rows.Next() {
+		var u TransferUsage
+		if err := rows.Scan(&u.UserID, &u.Period, &u.UploadBytes, &u.DownloadBytes); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
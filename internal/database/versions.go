@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// versionColumns lists the file_versions columns in the order
+// versionFields scans them.
+const versionColumns = "file_versions.file_id, file_versions.version, file_versions.path, file_versions.size, " +
+	"file_versions.mime_type, file_versions.sha256, file_versions.uploaded_by, file_versions.scan_status, " +
+	"file_versions.created_at, file_versions.version = files.version"
+
+func versionFields(v *FileVersion) []any {
+	return []any{
+		&v.FileID, &v.Version, &v.Path, &v.Size,
+		&v.MimeType, &v.SHA256, &v.UploadedBy, &v.ScanStatus,
+		&v.CreatedAt, &v.Current,
+	}
+}
+
+// ReserveFileVersion allocates the next version number of a file. Numbers
+// are never handed out twice, even when the upload using one fails, since
+// each version's content is encrypted under a key derived from its number.
+func ReserveFileVersion(ctx context.Context, fileID string) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "database.ReserveFileVersion", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	var version int
+	err = DB.QueryRowContext(ctx,
+		"UPDATE files SET last_version = last_version + 1 WHERE id = $1 RETURNING last_version",
+		fileID,
+	).Scan(&version)
+	return version, err
+}
+
+// AddFileVersion records new content for a file and makes it the current
+// version, unless a later version was added in the meantime. The file's
+// thumbnails and indexed text describe the old content, so they are
+// dropped; jobs are enqueued in the same transaction to regenerate them.
+func AddFileVersion(ctx context.Context, v FileVersion, jobs ...Job) (_ *File, err error) {
+	ctx, span := tracing.Start(ctx, "database.AddFileVersion",
+		attribute.String("file.id", v.FileID),
+		attribute.Int("file.version", v.Version),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO file_versions (file_id, version, path, size, mime_type, sha256, uploaded_by, scan_status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		v.FileID, v.Version, v.Path, v.Size, v.MimeType, v.SHA256, v.UploadedBy, v.ScanStatus,
+	); err != nil {
+		return nil, err
+	}
+	result, err := tx.ExecContext(ctx,
+		`UPDATE files
+		SET version = $2, path = $3, size = $4, mime_type = $5, sha256 = $6,
+			scan_status = $7, scan_signature = '', scanned_at = NULL, content_missing_at = NULL, corrupt_at = NULL,
+			search_vector = file_name_vector(name)
+		WHERE id = $1 AND version < $2`,
+		v.FileID, v.Version, v.Path, v.Size, v.MimeType, v.SHA256, v.ScanStatus,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n > 0 {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM thumbnails WHERE file_id = $1", v.FileID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM file_texts WHERE file_id = $1", v.FileID); err != nil {
+			return nil, err
+		}
+		for i := range jobs {
+			if err := insertJob(ctx, tx, &jobs[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var file File
+	if err := tx.QueryRowContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE id = $1",
+		v.FileID,
+	).Scan(fileFields(&file)...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := cache.InvalidateCache(ctx, "file:"+v.FileID); err != nil {
+		slog.WarnContext(ctx, "invalidating file cache failed", "error", err)
+	}
+	return &file, nil
+}
+
+// GetFileVersions returns a file's versions, newest first.
+func GetFileVersions(ctx context.Context, fileID string) (_ []FileVersion, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFileVersions", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+versionColumns+`
+		FROM file_versions JOIN files ON files.id = file_versions.file_id
+		WHERE file_versions.file_id = $1
+		ORDER BY file_versions.version DESC`,
+		fileID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []FileVersion{}
+	for rows.Next() {
+		var v FileVersion
+		if err := rows.Scan(versionFields(&v)...); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetFileVersion returns one version of a file.
+func GetFileVersion(ctx context.Context, fileID string, version int) (_ *FileVersion, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetFileVersion",
+		attribute.String("file.id", fileID),
+		attribute.Int("file.version", version),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	var v FileVersion
+	err = DB.QueryRowContext(ctx,
+		`SELECT `+versionColumns+`
+		FROM file_versions JOIN files ON files.id = file_versions.file_id
+		WHERE file_versions.file_id = $1 AND file_versions.version = $2`,
+		fileID, version,
+	).Scan(versionFields(&v)...)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// VersionCursor marks a position in file versions ordered by file ID and
+// version number. The zero value starts at the beginning.
+type VersionCursor struct {
+	FileID  string
+	Version int
+}
+
+// GetPrunableVersions returns up to limit non-current versions, after
+// cursor, that are either beyond the newest keep versions of their file or
+// were created before cutoff. A zero cutoff prunes by count only.
+func GetPrunableVersions(ctx context.Context, keep int, cutoff time.Time, cursor VersionCursor, limit int) (_ []FileVersion, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetPrunableVersions")
+	defer func() { tracing.End(span, err) }()
+
+	if cursor.FileID == "" {
+		cursor.FileID = "00000000-0000-0000-0000-000000000000"
+	}
+	rows, err := DB.QueryContext(ctx,
+		`SELECT `+versionColumns+`
+		FROM (
+			SELECT file_id, version,
+				row_number() OVER (PARTITION BY file_id ORDER BY version DESC) AS rank
+			FROM file_versions
+		) ranked
+		JOIN file_versions ON file_versions.file_id = ranked.file_id AND file_versions.version = ranked.version
+		JOIN files ON files.id = file_versions.file_id
+		WHERE file_versions.version <> files.version
+		AND (ranked.rank > $1 OR ($2 AND file_versions.created_at < $3))
+		AND (file_versions.file_id, file_versions.version) > ($4::uuid, $5)
+		ORDER BY file_versions.file_id, file_versions.version
+		LIMIT $6`,
+		keep, !cutoff.IsZero(), cutoff, cursor.FileID, cursor.Version, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []FileVersion
+	for rows.Next() {
+		var v FileVersion
+		if err := rows.Scan(versionFields(&v)...); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// DeleteFileVersion removes a version that is not current. It reports
+// whether a row was deleted.
+func DeleteFileVersion(ctx context.Context, fileID string, version int) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "database.DeleteFileVersion",
+		attribute.String("file.id", fileID),
+		attribute.Int("file.version", version),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	result, err := DB.ExecContext(ctx,
+		`DELETE FROM file_versions
+		WHERE file_id = $1 AND version = $2
+		AND version <> (SELECT version FROM files WHERE id = $1)`,
+		fileID, version,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetVersionPaths returns the storage paths of every version of a file.
+func GetVersionPaths(ctx context.Context, fileID string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "database.GetVersionPaths", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := DB.QueryContext(ctx, "SELECT path FROM file_versions WHERE file_id = $1", fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
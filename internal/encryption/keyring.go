@@ -0,0 +1,172 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the length of master and data keys: AES-256.
+const KeySize = 32
+
+var (
+	ErrUnknownKey = errors.New("unknown master key")
+	ErrNoKeyring  = errors.New("file is encrypted but no master key is configured")
+)
+
+// Default wraps data keys for new uploads and unwraps them for reads. When
+// nil, uploads are stored in plaintext.
+var Default *Keyring
+
+// Keyring holds the master keys that wrap per-file data keys. New data
+// keys are always wrapped with the active key; the others are kept only to
+// unwrap keys that have not been rotated yet.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring from raw master keys by ID.
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownKey, active)
+	}
+	k := &Keyring{active: active, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// keyFile is the on-disk format of a local key file:
+//
+//	{"active": "2026-10", "keys": {"2026-09": "<base64>", "2026-10": "<base64>"}}
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// LoadKeyFile reads a keyring from a local key file. To rotate, add a new
+// key, make it active and run "keys rotate"; remove the old key only once
+// no file uses it.
+func LoadKeyFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing key file %s: %w", path, err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s, key %q: %w", path, id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(f.Active, keys)
+}
+
+// ParseKey decodes a base64 master key.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("key is not valid base64")
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// GenerateKey returns a new random master key, base64 encoded.
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ActiveID returns the ID of the key new data keys are wrapped with.
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+// NewDataKey generates a data key for fileID and returns it along with
+// the active key ID and the data key wrapped by that key.
+func (k *Keyring) NewDataKey(fileID string) (dataKey []byte, keyID string, wrapped []byte, err error) {
+	dataKey = make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", nil, err
+	}
+	wrapped, err = k.wrap(k.active, dataKey, fileID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return dataKey, k.active, wrapped, nil
+}
+
+// Unwrap recovers the data key of fileID. The file ID is authenticated, so
+// a wrapped key cannot be moved to another file.
+func (k *Keyring) Unwrap(keyID string, wrapped []byte, fileID string) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is truncated")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(fileID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// Rewrap re-encrypts a wrapped data key under the active master key. The
+// file content, encrypted with the data key itself, is untouched.
+func (k *Keyring) Rewrap(keyID string, wrapped []byte, fileID string) (string, []byte, error) {
+	dataKey, err := k.Unwrap(keyID, wrapped, fileID)
+	if err != nil {
+		return "", nil, err
+	}
+	rewrapped, err := k.wrap(k.active, dataKey, fileID)
+	if err != nil {
+		return "", nil, err
+	}
+	return k.active, rewrapped, nil
+}
+
+func (k *Keyring) wrap(keyID string, dataKey []byte, fileID string) ([]byte, error) {
+	aead := k.keys[keyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(fileID)), nil
+}
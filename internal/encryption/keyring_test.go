@@ -0,0 +1,146 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func masterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestUnwrap(t *testing.T) {
+	keyring, err := NewKeyring("k1", map[string][]byte{"k1": masterKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, keyID, wrapped, err := keyring.NewDataKey("file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "k1" {
+		t.Errorf("key ID = %q, want k1", keyID)
+	}
+
+	got, err := keyring.Unwrap(keyID, wrapped, "file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Error("unwrapped key differs")
+	}
+	if _, err := keyring.Unwrap(keyID, wrapped, "file-2"); err == nil {
+		t.Error("a wrapped key unwrapped for another file")
+	}
+	if _, err := keyring.Unwrap("k0", wrapped, "file-1"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key error = %v, want ErrUnknownKey", err)
+	}
+	if _, err := keyring.Unwrap(keyID, wrapped[:4], "file-1"); err == nil {
+		t.Error("a truncated wrapped key unwrapped")
+	}
+}
+
+func TestRewrapAfterRotation(t *testing.T) {
+	old, err := NewKeyring("2026-09", map[string][]byte{"2026-09": masterKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, keyID, wrapped, err := old.NewDataKey("file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := plaintext(chunkSize + 5)
+	var sealed bytes.Buffer
+	w, err := NewWriter(&sealed, dataKey, "file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotation: a new active key, with the old one kept for unwrapping
+	rotated, err := NewKeyring("2026-10", map[string][]byte{"2026-09": masterKey(1), "2026-10": masterKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readable := func(keyring *Keyring, keyID string, wrapped []byte) {
+		t.Helper()
+		key, err := keyring.Unwrap(keyID, wrapped, "file-1")
+		if err != nil {
+			t.Fatalf("unwrapping under %s: %v", keyID, err)
+		}
+		r, err := NewReader(bytes.NewReader(sealed.Bytes()), int64(sealed.Len()), key, "file-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("content unwrapped under %s differs", keyID)
+		}
+	}
+
+	// Files not rotated yet stay readable
+	readable(rotated, keyID, wrapped)
+
+	newID, rewrapped, err := rotated.Rewrap(keyID, wrapped, "file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID != "2026-10" {
+		t.Errorf("rewrapped key ID = %q, want 2026-10", newID)
+	}
+	readable(rotated, newID, rewrapped)
+
+	// Once every file is rewrapped the old key can go
+	current, err := NewKeyring("2026-10", map[string][]byte{"2026-10": masterKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readable(current, newID, rewrapped)
+	if _, err := current.Unwrap(keyID, wrapped, "file-1"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unwrapping under a removed key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`{"active": "a", "keys": {"a": "`+encoded+`"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.ActiveID() != "a" {
+		t.Errorf("ActiveID = %q, want a", keyring.ActiveID())
+	}
+
+	for _, contents := range []string{
+		`{"active": "b", "keys": {"a": "` + encoded + `"}}`,
+		`{"active": "a", "keys": {"a": "c2hvcnQ="}}`,
+		`{"active": "a", "keys": {"a": "not base64!"}}`,
+		`not json`,
+	} {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeyFile(path); err == nil {
+			t.Errorf("LoadKeyFile(%s) succeeded", contents)
+		}
+	}
+}
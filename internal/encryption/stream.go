@@ -0,0 +1,295 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Stored files are a header followed by independently sealed chunks, so
+// any byte range can be decrypted by reading only the chunks it covers:
+//
+//	"FSE1" | chunk size (uint32) | chunk 0 | chunk 1 | ... | final chunk
+//
+// Each chunk is AES-GCM with the chunk index as nonce and the file ID plus
+// a final-chunk flag as additional data, which detects reordering,
+// truncation and chunks swapped between files.
+const (
+	magic      = "FSE1"
+	headerSize = len(magic) + 4
+	chunkSize  = 64 << 10
+)
+
+var ErrCorrupt = errors.New("encrypted file is corrupt")
+
+func chunkNonce(aead cipher.AEAD, index uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+func chunkAAD(fileID string, final bool) []byte {
+	aad := append([]byte(fileID), 0)
+	if final {
+		aad[len(aad)-1] = 1
+	}
+	return aad
+}
+
+// Writer encrypts everything written to it. Close must be called to seal
+// the final chunk; it does not close the underlying writer.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	fileID string
+	buf    []byte
+	index  uint64
+	err    error
+}
+
+// NewWriter starts an encrypted stream for fileID under dataKey.
+func NewWriter(w io.Writer, dataKey []byte, fileID string) (*Writer, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[len(magic):], chunkSize)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, fileID: fileID, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *Writer) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, so the
+		// last chunk is always the one sealed as final by Close.
+		if len(e.buf) == chunkSize {
+			if e.err = e.seal(false); e.err != nil {
+				return written, e.err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the final chunk.
+func (e *Writer) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.err = e.seal(true)
+	if e.err == nil {
+		e.err = os.ErrClosed
+		return nil
+	}
+	return e.err
+}
+
+func (e *Writer) seal(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.index), e.buf, chunkAAD(e.fileID, final))
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// Reader decrypts an encrypted stream with random access. It implements
+// io.ReadSeeker over the plaintext.
+type Reader struct {
+	r         io.ReaderAt
+	aead      cipher.AEAD
+	fileID    string
+	chunkSize int64
+	chunks    int64
+	size      int64
+	offset    int64
+
+	current int64
+	plain   []byte
+	sealed  []byte
+}
+
+// NewReader opens an encrypted stream of storedSize bytes read from r.
+func NewReader(r io.ReaderAt, storedSize int64, dataKey []byte, fileID string) (*Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %v", ErrCorrupt, err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	size := int64(binary.BigEndian.Uint32(header[len(magic):]))
+	if size == 0 {
+		return nil, fmt.Errorf("%w: zero chunk size", ErrCorrupt)
+	}
+
+	overhead := int64(aead.Overhead())
+	body := storedSize - int64(headerSize)
+	if body < overhead {
+		return nil, fmt.Errorf("%w: truncated", ErrCorrupt)
+	}
+	chunks := (body + size + overhead - 1) / (size + overhead)
+
+	return &Reader{
+		r:         r,
+		aead:      aead,
+		fileID:    fileID,
+		chunkSize: size,
+		chunks:    chunks,
+		size:      body - chunks*overhead,
+		current:   -1,
+		sealed:    make([]byte, size+overhead),
+	}, nil
+}
+
+// Size returns the plaintext length.
+func (d *Reader) Size() int64 {
+	return d.size
+}
+
+func (d *Reader) load(index int64) error {
+	if index == d.current {
+		return nil
+	}
+	stride := d.chunkSize + int64(d.aead.Overhead())
+	n, err := d.r.ReadAt(d.sealed, int64(headerSize)+index*stride)
+	if err != nil && !(errors.Is(err, io.EOF) && index == d.chunks-1) {
+		return err
+	}
+	final := index == d.chunks-1
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.aead, uint64(index)), d.sealed[:n], chunkAAD(d.fileID, final))
+	if err != nil {
+		d.current = -1
+		return fmt.Errorf("%w: chunk %d: %v", ErrCorrupt, index, err)
+	}
+	d.plain = plain
+	d.current = index
+	return nil
+}
+
+func (d *Reader) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	index := d.offset / d.chunkSize
+	if err := d.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, d.plain[d.offset-index*d.chunkSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+func (d *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+// File is a stored file opened for reading.
+type File interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type decryptingFile struct {
+	*Reader
+	io.Closer
+}
+
+// Open opens the stored file at path for reading, decrypting it with
+// Default when keyID is set and returning it as-is otherwise.
+func Open(path, fileID, keyID string, wrappedKey []byte) (File, error) {
+	return open(path, fileID, keyID, wrappedKey, "")
+}
+
+// OpenDerived opens content derived from a file, such as a thumbnail,
+// that was encrypted with DeriveKey for purpose under DerivedID.
+func OpenDerived(path, fileID, keyID string, wrappedKey []byte, purpose string) (File, error) {
+	return open(path, fileID, keyID, wrappedKey, purpose)
+}
+
+func open(path, fileID, keyID string, wrappedKey []byte, purpose string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		return f, nil
+	}
+
+	r, err := openEncrypted(f, fileID, keyID, wrappedKey, purpose)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return decryptingFile{Reader: r, Closer: f}, nil
+}
+
+func openEncrypted(f *os.File, fileID, keyID string, wrappedKey []byte, purpose string) (*Reader, error) {
+	if Default == nil {
+		return nil, ErrNoKeyring
+	}
+	key, err := Default.Unwrap(keyID, wrappedKey, fileID)
+	if err != nil {
+		return nil, err
+	}
+	id := fileID
+	if purpose != "" {
+		if key, err = DeriveKey(key, purpose); err != nil {
+			return nil, err
+		}
+		id = DerivedID(fileID, purpose)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(f, info.Size(), key, id)
+}
+
+// DeriveKey derives the key for content generated from a file, such as a
+// thumbnail, from the file's data key. Derived content needs no wrapped key
+// of its own and follows the file's key through rotation. Each purpose
+// gets an independent key, so chunk nonces are never reused.
+func DeriveKey(dataKey []byte, purpose string) ([]byte, error) {
+	return hkdf.Key(sha256.New, dataKey, nil, "fileshare derived "+purpose, KeySize)
+}
+
+// DerivedID is the ID content derived for purpose is authenticated with.
+func DerivedID(fileID, purpose string) string {
+	return fileID + "/" + purpose
+}
@@ -0,0 +1,189 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{7}, KeySize)
+
+// plaintext returns n bytes that differ from chunk to chunk, so a chunk
+// read from the wrong place cannot go unnoticed.
+func plaintext(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i*31 + i/chunkSize)
+	}
+	return p
+}
+
+func encrypt(t *testing.T, plain []byte, fileID string) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	w, err := NewWriter(&sealed, testKey, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func decrypt(sealed []byte, fileID string) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), testKey, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		plain := plaintext(size)
+		sealed := encrypt(t, plain, "file-1")
+
+		r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), testKey, "file-1")
+		if err != nil {
+			t.Fatalf("size %d: NewReader: %v", size, err)
+		}
+		if r.Size() != int64(size) {
+			t.Errorf("size %d: Size = %d", size, r.Size())
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: reading: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+func TestWriteInPieces(t *testing.T) {
+	plain := plaintext(2*chunkSize + 100)
+	var sealed bytes.Buffer
+	w, err := NewWriter(&sealed, testKey, "file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rest := plain; len(rest) > 0; {
+		n := min(len(rest), 1000)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Chunk nonces are their index, so the stream depends only on the key,
+	// the file ID and the content, not on how it was written
+	if !bytes.Equal(sealed.Bytes(), encrypt(t, plain, "file-1")) {
+		t.Error("stored content depends on how it was written")
+	}
+}
+
+func TestSeek(t *testing.T) {
+	plain := plaintext(3*chunkSize + 10)
+	sealed := encrypt(t, plain, "file-1")
+	r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), testKey, "file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		length int
+		start  int64
+	}{
+		{"across the first boundary", chunkSize - 5, io.SeekStart, 10, chunkSize - 5},
+		{"spanning a whole chunk", chunkSize - 1, io.SeekStart, chunkSize + 2, chunkSize - 1},
+		{"from the end", -20, io.SeekEnd, 20, int64(len(plain)) - 20},
+		{"back into an earlier chunk", 3, io.SeekStart, 4, 3},
+		{"relative", chunkSize, io.SeekCurrent, 8, 3 + 4 + chunkSize},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil {
+			t.Fatalf("%s: Seek: %v", tt.name, err)
+		}
+		if pos != tt.start {
+			t.Fatalf("%s: Seek = %d, want %d", tt.name, pos, tt.start)
+		}
+		got := make([]byte, tt.length)
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("%s: reading: %v", tt.name, err)
+		}
+		if want := plain[tt.start : tt.start+int64(tt.length)]; !bytes.Equal(got, want) {
+			t.Errorf("%s: read the wrong bytes", tt.name)
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end = %d, %v, want EOF", n, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeking before the start succeeded")
+	}
+}
+
+func TestTampering(t *testing.T) {
+	const fileID = "file-1"
+	plain := plaintext(2*chunkSize + 10)
+	sealed := encrypt(t, plain, fileID)
+	stride := chunkSize + 16 // GCM tag
+	chunk := func(i int) []byte {
+		end := min(headerSize+(i+1)*stride, len(sealed))
+		return sealed[headerSize+i*stride : end]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	header := sealed[:headerSize]
+
+	other := encrypt(t, plain, "file-2")
+	tests := []struct {
+		name   string
+		stored []byte
+		fileID string
+	}{
+		{"final chunk dropped", join(header, chunk(0), chunk(1)), fileID},
+		{"cut inside the final chunk", sealed[:len(sealed)-3], fileID},
+		{"cut to the header", header, fileID},
+		{"chunks reordered", join(header, chunk(1), chunk(0), chunk(2)), fileID},
+		{"chunk from another file", join(header, chunk(0), other[headerSize+stride:headerSize+2*stride], chunk(2)), fileID},
+		{"wrong file ID", sealed, "file-2"},
+		{"bit flipped", join(header, chunk(0), append([]byte{chunk(1)[0] ^ 1}, chunk(1)[1:]...), chunk(2)), fileID},
+		{"bad magic", join([]byte("XXXX"), sealed[len(magic):]), fileID},
+	}
+	for _, tt := range tests {
+		if _, err := decrypt(tt.stored, tt.fileID); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: error = %v, want ErrCorrupt", tt.name, err)
+		}
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	thumb, err := DeriveKey(testKey, "thumbnail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, err := DeriveKey(testKey, "preview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumb) != KeySize || bytes.Equal(thumb, preview) || bytes.Equal(thumb, testKey) {
+		t.Error("derived keys are not independent of the data key and each other")
+	}
+}
@@ -0,0 +1,137 @@
+// Package extract pulls searchable text out of uploaded documents.
+package extract
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// MaxText caps the text kept from one document. Postgres limits a tsvector
+// to 1 MB, which much larger documents could exceed.
+var MaxText = 256 << 10
+
+// maxInput bounds how much of a document is read to find that text.
+const maxInput = 64 << 20
+
+var ErrUnsupported = errors.New("unsupported document type")
+
+var extractors = map[string]func(io.Reader) (string, error){
+	"text/plain":       plainText,
+	"text/markdown":    plainText,
+	"text/x-markdown":  plainText,
+	"text/csv":         plainText,
+	"application/json": jsonText,
+	"text/html":        htmlText,
+	"application/pdf":  pdfText,
+}
+
+// Supported reports whether text can be extracted from mimeType. Parameters
+// such as charset are ignored.
+func Supported(mimeType string) bool {
+	_, ok := extractors[baseType(mimeType)]
+	return ok
+}
+
+// Text returns the text of the document in r, truncated to MaxText bytes
+// of valid UTF-8 without NUL characters, which Postgres cannot store.
+func Text(r io.Reader, mimeType string) (string, error) {
+	extract, ok := extractors[baseType(mimeType)]
+	if !ok {
+		return "", ErrUnsupported
+	}
+	text, err := extract(io.LimitReader(r, maxInput))
+	if err != nil {
+		return "", err
+	}
+	return clean(text), nil
+}
+
+func baseType(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+	return mediaType
+}
+
+func clean(text string) string {
+	if len(text) > MaxText {
+		// Any character cut in half is dropped as invalid below.
+		text = text[:MaxText]
+	}
+	text = strings.ToValidUTF8(text, "")
+	return strings.ReplaceAll(text, "\x00", "")
+}
+
+func plainText(r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(MaxText)+utf8.UTFMax))
+	return string(data), err
+}
+
+// jsonText joins the keys and string values of a JSON document.
+func jsonText(r io.Reader) (string, error) {
+	var b strings.Builder
+	dec := json.NewDecoder(r)
+	for b.Len() < MaxText {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if s, ok := tok.(string); ok {
+			b.WriteString(s)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String(), nil
+}
+
+// htmlText returns the visible text of an HTML document, skipping scripts
+// and styles.
+func htmlText(r io.Reader) (string, error) {
+	var b strings.Builder
+	z := html.NewTokenizer(bufio.NewReader(r))
+	skip := 0
+	for b.Len() < MaxText {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return b.String(), nil
+			}
+			return "", z.Err()
+		case html.StartTagToken:
+			if name, _ := z.TagName(); isHidden(name) {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); isHidden(name) && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				if text := strings.TrimSpace(string(z.Text())); text != "" {
+					b.WriteString(text)
+					b.WriteByte('\n')
+				}
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func isHidden(tag []byte) bool {
+	switch string(tag) {
+	case "script", "style", "noscript", "template":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pdfText extracts the strings shown by text operators in a PDF's content
+// streams. It handles uncompressed and Flate-compressed streams and fonts
+// with a single-byte encoding, which covers most generated documents;
+// text in CID fonts or behind other filters is skipped, and scanned pages
+// have no text at all.
+func pdfText(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for rest := data; b.Len() < MaxText; {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		dict := streamDict(rest[:start])
+		body := rest[start+len("stream"):]
+		body = bytes.TrimPrefix(body, []byte("\r"))
+		body = bytes.TrimPrefix(body, []byte("\n"))
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		rest = body[end+len("endstream"):]
+
+		content, ok := decodeStream(dict, body[:end])
+		if !ok || !bytes.Contains(content, []byte("BT")) {
+			continue
+		}
+		showText(&b, content)
+	}
+	return b.String(), nil
+}
+
+// streamDict returns the dictionary preceding a stream keyword.
+func streamDict(before []byte) []byte {
+	if i := bytes.LastIndex(before, []byte("obj")); i >= 0 {
+		return before[i:]
+	}
+	return nil
+}
+
+// decodeStream undoes a stream's filter when it is absent or FlateDecode.
+// Output is capped to guard against compression bombs.
+func decodeStream(dict, body []byte) ([]byte, bool) {
+	if !bytes.Contains(dict, []byte("/Filter")) {
+		return body, true
+	}
+	filters := bytes.Count(dict, []byte("Decode"))
+	if filters != 1 || !bytes.Contains(dict, []byte("/FlateDecode")) {
+		return nil, false
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, int64(MaxText)*8))
+	if err != nil && len(out) == 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// showText appends the operands of Tj, TJ, ' and " operators inside BT/ET
+// blocks, starting a new line on line-moving operators.
+func showText(b *strings.Builder, content []byte) {
+	var operands [][]byte
+	inText := false
+	for i := 0; i < len(content) && b.Len() < MaxText; {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := literalString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			s, n := hexString(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isRegular(c):
+			j := i + 1
+			for j < len(content) && isRegular(content[j]) && content[j] != '/' {
+				j++
+			}
+			op := string(content[i:j])
+			i = j
+			switch op {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				b.WriteByte('\n')
+			case "Tj", "TJ", "'", "\"":
+				if inText {
+					if op == "'" || op == "\"" {
+						b.WriteByte('\n')
+					}
+					for _, s := range operands {
+						b.Write(s)
+					}
+				}
+			case "T*", "Td", "TD", "Tm":
+				if inText {
+					b.WriteByte('\n')
+				}
+			}
+			if v, err := strconv.ParseFloat(op, 64); err == nil {
+				// A wide gap between the strings of a TJ array is a space.
+				if v <= -200 && len(operands) > 0 {
+					operands = append(operands, []byte(" "))
+				}
+			} else if op[0] != '/' {
+				operands = operands[:0]
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// isRegular reports whether c can be part of an operator, number or name.
+func isRegular(c byte) bool {
+	return strings.IndexByte(" \t\r\n\f\x00()<>{}[]%", c) < 0
+}
+
+// literalString decodes a (...) string and returns it with the number of
+// bytes consumed.
+func literalString(p []byte) ([]byte, int) {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return printable(out), i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(p) {
+				break
+			}
+			switch e := p[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation.
+			default:
+				if e >= '0' && e <= '7' {
+					v, j := 0, i
+					for ; j < len(p) && j < i+3 && p[j] >= '0' && p[j] <= '7'; j++ {
+						v = v*8 + int(p[j]-'0')
+					}
+					out = append(out, byte(v))
+					i = j - 1
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return printable(out), len(p)
+}
+
+// hexString decodes a <...> string. Strings that do not decode to
+// printable text, such as glyph IDs of CID fonts, are dropped.
+func hexString(p []byte) ([]byte, int) {
+	end := bytes.IndexByte(p, '>')
+	if end < 0 {
+		return nil, len(p)
+	}
+	var digits []byte
+	for _, c := range p[1:end] {
+		if strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	for _, c := range out {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return nil, end + 1
+		}
+	}
+	return printable(out), end + 1
+}
+
+// printable maps PDFDocEncoding/WinAnsi bytes to UTF-8, treating bytes
+// above 0x7f as Latin-1.
+func printable(p []byte) []byte {
+	out := make([]byte, 0, len(p))
+	for _, c := range p {
+		if c < 0x80 {
+			out = append(out, c)
+			continue
+		}
+		out = append(out, string(rune(c))...)
+	}
+	return out
+}
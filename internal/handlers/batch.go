@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/throttle"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+)
+
+// Limits on the number of files in one batch request or archive.
+const (
+	maxBatchFiles   = 100
+	maxArchiveFiles = 1000
+)
+
+// Batch actions.
+const (
+	batchDelete  = "delete"
+	batchMove    = "move"
+	batchShare   = "share"
+	batchUnshare = "unshare"
+)
+
+// batchResult is the outcome of a batch action on one file, with the HTTP
+// status the equivalent single-file request would have had.
+type batchResult struct {
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchFiles applies one action to up to 100 of the user's files and
+// reports the outcome for each ID: "delete" removes them, "move" puts them
+// in "folder", and "share" and "unshare" make them public or private.
+func BatchFiles(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	var req struct {
+		Action string   `json:"action" binding:"required"`
+		IDs    []string `json:"ids" binding:"required"`
+		Folder *string  `json:"folder"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.IDs) == 0 || len(req.IDs) > maxBatchFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must list between 1 and " + strconv.Itoa(maxBatchFiles) + " files"})
+		return
+	}
+	var folder string
+	switch req.Action {
+	case batchDelete, batchShare, batchUnshare:
+	case batchMove:
+		if req.Folder == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "folder is required to move files"})
+			return
+		}
+		var err error
+		if folder, err = upload.NormalizeFolder(*req.Folder); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be delete, move, share or unshare"})
+		return
+	}
+
+	// Look every file up first so ownership is checked for the whole batch
+	// in one query.
+	results := make([]batchResult, len(req.IDs))
+	ids := make([]string, len(req.IDs))
+	for i, id := range req.IDs {
+		results[i].ID = id
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, "invalid file id"
+			continue
+		}
+		ids[i] = parsed.String()
+	}
+	files, err := database.GetFilesByIDs(ctx, compact(ids))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get files"})
+		return
+	}
+	byID := make(map[string]*database.File, len(files))
+	for i := range files {
+		byID[files[i].ID] = &files[i]
+	}
+	var owned []string
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+		switch file := byID[id]; {
+		case file == nil:
+			results[i].Status, results[i].Error = http.StatusNotFound, "file not found"
+		case file.UserID != user.ID:
+			results[i].Status, results[i].Error = http.StatusForbidden, "unauthorized access"
+		default:
+			owned = append(owned, id)
+		}
+	}
+
+	done := make(map[string]error)
+	switch req.Action {
+	case batchDelete:
+		for _, id := range owned {
+			if _, seen := done[id]; !seen {
+				done[id] = worker.RemoveFile(ctx, byID[id])
+			}
+		}
+	case batchMove, batchShare, batchUnshare:
+		var updated []string
+		if req.Action == batchMove {
+			updated, err = database.MoveFiles(ctx, user.ID, owned, folder)
+		} else {
+			updated, err = database.SetFilesPublic(ctx, user.ID, owned, req.Action == batchShare)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update files"})
+			return
+		}
+		for _, id := range owned {
+			done[id] = sql.ErrNoRows
+		}
+		for _, id := range updated {
+			done[id] = nil
+		}
+	}
+
+	for i, id := range ids {
+		err, ok := done[id]
+		switch {
+		case !ok:
+		case errors.Is(err, sql.ErrNoRows):
+			// Deleted concurrently.
+			results[i].Status, results[i].Error = http.StatusNotFound, "file not found"
+		case err != nil:
+			slog.ErrorContext(ctx, "batch action failed", "action", req.Action, "file_id", id, "error", err)
+			results[i].Status, results[i].Error = http.StatusInternalServerError, "failed to "+req.Action+" file"
+		default:
+			results[i].Status = http.StatusOK
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"action": req.Action, "results": results})
+}
+
+// DownloadArchive streams a zip of either the listed files ("ids"), which
+// may include other users' public files, or every file in one of the
+// user's folders and its subfolders ("folder"). The archive is written as
+// it is read, so nothing is buffered in memory. Listed files must all be
+// downloadable; files in a folder that are not are left out and counted
+// in the X-Archive-Skipped header.
+func DownloadArchive(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	var req struct {
+		IDs    []string `json:"ids"`
+		Folder *string  `json:"folder"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (len(req.IDs) == 0) == (req.Folder == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "give either ids or folder"})
+		return
+	}
+
+	var files []database.File
+	var names []string
+	archiveName := "files.zip"
+	skipped := 0
+	if req.Folder == nil {
+		if len(req.IDs) > maxArchiveFiles {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at most " + strconv.Itoa(maxArchiveFiles) + " files can be archived"})
+			return
+		}
+		ids := make([]string, 0, len(req.IDs))
+		for _, id := range req.IDs {
+			parsed, err := uuid.Parse(id)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file id", "id": id})
+				return
+			}
+			ids = append(ids, parsed.String())
+		}
+		ids = compact(ids)
+		found, err := database.GetFilesByIDs(ctx, ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get files"})
+			return
+		}
+		byID := make(map[string]database.File, len(found))
+		for _, file := range found {
+			byID[file.ID] = file
+		}
+		for _, id := range ids {
+			file, ok := byID[id]
+			if !ok || (!file.IsPublic && file.UserID != user.ID) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "file not found", "id": id})
+				return
+			}
+			if !archivable(&file) {
+				c.JSON(http.StatusConflict, gin.H{"error": "file cannot be downloaded", "id": id, "scan_status": file.ScanStatus})
+				return
+			}
+			files = append(files, file)
+			names = append(names, file.Name)
+		}
+	} else {
+		folder, err := upload.NormalizeFolder(*req.Folder)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		found, err := database.GetFilesInFolder(ctx, user.ID, folder, maxArchiveFiles+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get files"})
+			return
+		}
+		if len(found) > maxArchiveFiles {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "folder has more than " + strconv.Itoa(maxArchiveFiles) + " files"})
+			return
+		}
+		for _, file := range found {
+			if !archivable(&file) {
+				skipped++
+				continue
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(file.Folder, folder), "/")
+			files = append(files, file)
+			names = append(names, path.Join(rel, file.Name))
+		}
+		if folder != "/" {
+			archiveName = path.Base(folder) + ".zip"
+		}
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no files to archive", "skipped": skipped})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archiveName}))
+	c.Header("X-Archive-Skipped", strconv.Itoa(skipped))
+	c.Status(http.StatusOK)
+
+	counter := &countingWriter{w: throttle.Download.Writer(ctx, user.ID, c.Writer)}
+	defer func() {
+		metrics.DownloadBytes.Add(float64(counter.n))
+		if counter.n > 0 {
+			if err := database.RecordTransfer(ctx, user.ID, 0, counter.n); err != nil {
+				slog.WarnContext(ctx, "recording download transfer failed", "error", err)
+			}
+		}
+	}()
+
+	// An error after the first byte can no longer be reported; the
+	// archive is left without its central directory so clients see it is
+	// incomplete.
+	zw := zip.NewWriter(counter)
+	seen := make(map[string]int)
+	for i := range files {
+		file := &files[i]
+		if err := addToArchive(ctx, zw, file, uniqueName(seen, names[i])); err != nil {
+			slog.ErrorContext(ctx, "writing archive failed", "file_id", file.ID, "error", err)
+			c.Abort()
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		slog.ErrorContext(ctx, "finishing archive failed", "error", err)
+	}
+}
+
+// archivable reports whether a file's content can be served.
+func archivable(file *database.File) bool {
+	return file.ScanStatus == scan.StatusClean && file.ContentMissingAt == nil && file.CorruptAt == nil
+}
+
+// addToArchive copies a file's decrypted content into the archive.
+// Formats that are already compressed are stored as they are.
+func addToArchive(ctx context.Context, zw *zip.Writer, file *database.File, name string) error {
+	content, err := openStoredFile(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: file.CreatedAt}
+	if compressed(file.MimeType) {
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+// compressed reports whether content of mimeType gains nothing from
+// deflate.
+func compressed(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch {
+	case strings.HasPrefix(mimeType, "image/") && mimeType != "image/svg+xml" && mimeType != "image/bmp",
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "audio/"):
+		return true
+	}
+	switch mimeType {
+	case "application/zip", "application/gzip", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/x-xz", "application/zstd":
+		return true
+	}
+	return false
+}
+
+// uniqueName returns name, or name with a " (n)" suffix before its
+// extension if an earlier entry already used it.
+func uniqueName(seen map[string]int, name string) string {
+	seen[name]++
+	if seen[name] == 1 {
+		return name
+	}
+	ext := path.Ext(name)
+	for n := seen[name]; ; n++ {
+		candidate := strings.TrimSuffix(name, ext) + " (" + strconv.Itoa(n) + ")" + ext
+		if seen[candidate] == 0 {
+			seen[candidate] = 1
+			return candidate
+		}
+	}
+}
+
+// compact drops empty and repeated IDs, keeping the first occurrence.
+func compact(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/objectstore"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+)
+
+// directCompleteWindow is how long after its upload URLs expire a direct
+// upload can still be completed, so a transfer that started in time can
+// finish.
+const directCompleteWindow = time.Hour
+
+// directPart is a presigned URL for one part of a multipart direct upload.
+type directPart struct {
+	Number int    `json:"part_number"`
+	Size   int64  `json:"size"`
+	URL    string `json:"url"`
+}
+
+// StartDirectUpload reserves a file ID for a file called "name" in
+// "folder" and returns presigned URLs that upload its content straight to
+// the object store, bypassing the API. Content up to the configured part
+// size takes a single PUT to "url"; larger content is split into "parts",
+// each PUT to its own URL. The client declares the content's "size" and
+// "sha256" up front and calls the "complete_url" once the upload is done.
+func StartDirectUpload(c *gin.Context) {
+	if objectstore.Default == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "direct uploads are not enabled"})
+		return
+	}
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	var req struct {
+		presignOptions
+		Name   string  `json:"name" binding:"required"`
+		Folder *string `json:"folder"`
+		Size   int64   `json:"size" binding:"required"`
+		SHA256 string  `json:"sha256" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := upload.ValidateFilename(req.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	folder := "/"
+	if req.Folder != nil {
+		var err error
+		if folder, err = upload.NormalizeFolder(*req.Folder); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	switch {
+	case req.Size < 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive"})
+		return
+	case req.Size > upload.MaxSize:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": upload.ErrTooLarge.Error()})
+		return
+	}
+	digest := strings.ToLower(req.SHA256)
+	if sum, err := hex.DecodeString(digest); err != nil || len(sum) != sha256.Size {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 must be a hex-encoded SHA-256 digest"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	store := objectstore.Default
+	fileID := uuid.New().String()
+	slot := database.FileSlot{
+		FileID:    fileID,
+		UserID:    user.ID,
+		Name:      req.Name,
+		Folder:    folder,
+		ObjectKey: "uploads/" + fileID,
+		Size:      req.Size,
+		SHA256:    digest,
+	}
+	ctx = logging.With(ctx, slog.String("file_id", fileID))
+	ttl := req.ttl()
+	expires := time.Now().Add(ttl)
+
+	result := gin.H{"file_id": fileID, "method": http.MethodPut, "expires_at": expires, "complete_url": "/files/direct/" + fileID + "/complete"}
+	if req.Size <= objectstore.PartSize {
+		result["url"] = store.PresignPut(slot.ObjectKey, ttl)
+	} else {
+		partSize := objectstore.PartSize
+		if req.Size > partSize*objectstore.MaxParts {
+			partSize = (req.Size + objectstore.MaxParts - 1) / objectstore.MaxParts
+		}
+		var err error
+		if slot.MultipartID, err = store.CreateMultipartUpload(ctx, slot.ObjectKey); err != nil {
+			slog.ErrorContext(ctx, "starting multipart upload failed", "error", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to start upload"})
+			return
+		}
+		var parts []directPart
+		for offset, number := int64(0), 1; offset < req.Size; offset, number = offset+partSize, number+1 {
+			parts = append(parts, directPart{
+				Number: number,
+				Size:   min(partSize, req.Size-offset),
+				URL:    store.PresignPart(slot.ObjectKey, slot.MultipartID, number, ttl),
+			})
+		}
+		result["parts"] = parts
+		result["part_size"] = partSize
+	}
+
+	slot.ExpiresAt = expires.Add(directCompleteWindow)
+	created, err := database.CreateFileSlot(ctx, slot)
+	if err != nil {
+		if slot.MultipartID != "" {
+			store.AbortMultipartUpload(context.WithoutCancel(ctx), slot.ObjectKey, slot.MultipartID)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reserve file"})
+		return
+	}
+	result["slot"] = created
+	c.JSON(http.StatusCreated, result)
+}
+
+// CompleteDirectUpload finishes a direct upload started with
+// StartDirectUpload. Multipart uploads send the "parts" they uploaded, with
+// the ETag each PUT returned. The staged object is checked against the
+// declared size and SHA-256 and its type against the upload policies, then
+// copied into encrypted storage like any other upload and deleted from the
+// object store. Each slot completes once: it is used up even if the checks
+// fail.
+func CompleteDirectUpload(c *gin.Context) {
+	if objectstore.Default == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "direct uploads are not enabled"})
+		return
+	}
+	user := c.MustGet("user").(*database.User)
+	fileID := c.Param("id")
+	ctx := logging.With(c.Request.Context(), slog.String("file_id", fileID))
+	if _, err := uuid.Parse(fileID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload slot not found"})
+		return
+	}
+
+	var req struct {
+		Parts []objectstore.Part `json:"parts"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	slot, err := database.ClaimFileSlot(ctx, fileID, user.ID, true)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload slot not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to claim upload slot"})
+		return
+	}
+
+	// The staged object is only needed until its content has been copied
+	store := objectstore.Default
+	completed := slot.MultipartID == ""
+	defer func() {
+		ctx := context.WithoutCancel(ctx)
+		var err error
+		if completed {
+			err = store.Delete(ctx, slot.ObjectKey)
+		} else {
+			err = store.AbortMultipartUpload(ctx, slot.ObjectKey, slot.MultipartID)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "deleting staged upload failed", "object_key", slot.ObjectKey, "error", err)
+		}
+	}()
+
+	uploadID := uuid.New().String()
+	if _, err := database.CreateUpload(ctx, database.Upload{ID: uploadID, UserID: user.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start upload"})
+		return
+	}
+	c.Header("X-Upload-ID", uploadID)
+	fail := func(status int, reason string) {
+		failUpload(ctx, uploadID, reason)
+		c.JSON(status, gin.H{"error": reason, "upload_id": uploadID})
+	}
+
+	if !completed {
+		if status, err := completeStagedUpload(ctx, store, slot, req.Parts); err != nil {
+			fail(status, err.Error())
+			return
+		}
+		completed = true
+	}
+
+	object, status, err := openStagedUpload(ctx, store, slot)
+	if err != nil {
+		fail(status, err.Error())
+		return
+	}
+	received, status, err := checkContent(ctx, user.ID, slot.Name, object)
+	if err != nil {
+		object.Close()
+		fail(status, err.Error())
+		return
+	}
+	defer received.src.Close()
+	received.fileID = slot.FileID
+	received.size = slot.Size
+
+	file, err := storeUpload(context.WithoutCancel(ctx), uploadID, user.ID, received, uploadLabels{folder: slot.Folder})
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error(), "upload_id": uploadID})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"upload_id": uploadID, "file": file})
+}
+
+// completeStagedUpload assembles the parts of slot's multipart upload. On
+// failure it returns the status to respond with.
+func completeStagedUpload(ctx context.Context, store *objectstore.Client, slot *database.FileSlot, parts []objectstore.Part) (int, error) {
+	if len(parts) == 0 {
+		return http.StatusBadRequest, errors.New("parts are required to complete a multipart upload")
+	}
+	if err := store.CompleteMultipartUpload(ctx, slot.ObjectKey, slot.MultipartID, parts); err != nil {
+		return http.StatusBadRequest, errors.New("failed to complete multipart upload: " + err.Error())
+	}
+	return 0, nil
+}
+
+// openStagedUpload opens slot's staged object once its size matches the
+// declared one. Reading it fails with upload.ErrChecksumMismatch at the end
+// if its SHA-256 does not. On failure it returns the status to respond
+// with.
+func openStagedUpload(ctx context.Context, store *objectstore.Client, slot *database.FileSlot) (io.ReadCloser, int, error) {
+	size, err := store.Size(ctx, slot.ObjectKey)
+	switch {
+	case errors.Is(err, objectstore.ErrNotFound):
+		return nil, http.StatusBadRequest, errors.New("content has not been uploaded")
+	case err != nil:
+		slog.ErrorContext(ctx, "reading staged upload failed", "error", err)
+		return nil, http.StatusBadGateway, errors.New("failed to read uploaded content")
+	case size != slot.Size:
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("uploaded content is %d bytes, %d were declared", size, slot.Size)
+	}
+
+	object, err := store.Get(ctx, slot.ObjectKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "reading staged upload failed", "error", err)
+		return nil, http.StatusBadGateway, errors.New("failed to read uploaded content")
+	}
+	digest, _ := hex.DecodeString(slot.SHA256)
+	return verifiedBody(object, upload.Checksums{SHA256: digest}), 0, nil
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/objectstore"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+)
+
+// fakeBucket serves the subset of the S3 API a direct upload uses, for the
+// path-style bucket "staging".
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	query := r.URL.Query()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/") && query.Get("X-Amz-Signature") == "" {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/staging/")
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	uploadID := query.Get("uploadId")
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID = fmt.Sprintf("upload-%d", len(b.uploads)+1)
+		b.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case r.Method == http.MethodPut && uploadID != "":
+		parts, ok := b.uploads[uploadID]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body
+		w.Header().Set("ETag", partETag(body))
+	case r.Method == http.MethodPut:
+		b.objects[key] = body
+	case r.Method == http.MethodPost && uploadID != "":
+		parts, ok := b.uploads[uploadID]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []objectstore.Part `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			s3Error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var object []byte
+		for _, part := range complete.Parts {
+			content, ok := parts[part.Number]
+			if !ok || part.ETag != partETag(content) {
+				s3Error(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			object = append(object, content...)
+		}
+		b.objects[key] = object
+		delete(b.uploads, uploadID)
+		io.WriteString(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		object, ok := b.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		w.Write(object)
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(b.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func partETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// useFakeBucket points objectstore.Default at a fake bucket.
+func useFakeBucket(t *testing.T) *objectstore.Client {
+	t.Helper()
+	server := httptest.NewServer(&fakeBucket{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)})
+	t.Cleanup(server.Close)
+	store, err := objectstore.New(objectstore.Options{
+		Endpoint:        server.URL,
+		Bucket:          "staging",
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := objectstore.Default
+	objectstore.Default = store
+	t.Cleanup(func() { objectstore.Default = previous })
+	return store
+}
+
+// put uploads content to a presigned URL as a client would, returning the
+// ETag.
+func put(t *testing.T, url string, content []byte) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT to presigned URL = %d", resp.StatusCode)
+	}
+	return resp.Header.Get("ETag")
+}
+
+func stagedSlot(key string, content []byte) *database.FileSlot {
+	sum := sha256.Sum256(content)
+	return &database.FileSlot{ObjectKey: key, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+func TestStagedUploadSinglePut(t *testing.T) {
+	store := useFakeBucket(t)
+	ctx := context.Background()
+	content := []byte("uploaded straight to the bucket\n")
+	put(t, store.PresignPut("uploads/a", time.Minute), content)
+
+	object, status, err := openStagedUpload(ctx, store, stagedSlot("uploads/a", content))
+	if err != nil {
+		t.Fatalf("openStagedUpload = %d, %v", status, err)
+	}
+	got, err := io.ReadAll(object)
+	object.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("staged content = %q, %v", got, err)
+	}
+
+	if _, status, err := openStagedUpload(ctx, store, stagedSlot("uploads/missing", content)); status != http.StatusBadRequest {
+		t.Errorf("missing object = %d, %v, want 400", status, err)
+	}
+
+	longer := stagedSlot("uploads/a", content)
+	longer.Size++
+	if _, status, err := openStagedUpload(ctx, store, longer); status != http.StatusUnprocessableEntity {
+		t.Errorf("size mismatch = %d, %v, want 422", status, err)
+	}
+}
+
+func TestStagedUploadHashMismatch(t *testing.T) {
+	store := useFakeBucket(t)
+	ctx := context.Background()
+	// The same length, so only the digest gives the swap away
+	declared := []byte("the content that was declared\n")
+	uploaded := []byte("other content, uploaded instead")[:len(declared)]
+	put(t, store.PresignPut("uploads/a", time.Minute), uploaded)
+
+	object, status, err := openStagedUpload(ctx, store, stagedSlot("uploads/a", declared))
+	if err != nil {
+		t.Fatalf("openStagedUpload = %d, %v", status, err)
+	}
+	if _, err := io.ReadAll(object); !errors.Is(err, upload.ErrChecksumMismatch) {
+		t.Errorf("reading = %v, want ErrChecksumMismatch", err)
+	}
+	object.Close()
+
+	// The mismatch is reported before the upload policies are consulted
+	object, _, err = openStagedUpload(ctx, store, stagedSlot("uploads/a", declared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	if _, status, err := checkContent(ctx, "u1", "a.txt", object); status != http.StatusUnprocessableEntity || !errors.Is(err, upload.ErrChecksumMismatch) {
+		t.Errorf("checkContent = %d, %v, want 422 ErrChecksumMismatch", status, err)
+	}
+}
+
+func TestStagedUploadMultipart(t *testing.T) {
+	store := useFakeBucket(t)
+	ctx := context.Background()
+	parts := [][]byte{bytes.Repeat([]byte("a"), 100), []byte("tail")}
+
+	uploadID, err := store.CreateMultipartUpload(ctx, "uploads/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot := stagedSlot("uploads/m", bytes.Join(parts, nil))
+	slot.MultipartID = uploadID
+
+	var uploaded []objectstore.Part
+	for i, content := range parts {
+		etag := put(t, store.PresignPart(slot.ObjectKey, uploadID, i+1, time.Minute), content)
+		uploaded = append(uploaded, objectstore.Part{Number: i + 1, ETag: etag})
+	}
+
+	if status, err := completeStagedUpload(ctx, store, slot, nil); status != http.StatusBadRequest {
+		t.Errorf("completing without parts = %d, %v, want 400", status, err)
+	}
+	forged := []objectstore.Part{uploaded[0], {Number: 2, ETag: `"forged"`}}
+	if status, err := completeStagedUpload(ctx, store, slot, forged); status != http.StatusBadRequest {
+		t.Errorf("completing with a wrong ETag = %d, %v, want 400", status, err)
+	}
+	if status, err := completeStagedUpload(ctx, store, slot, uploaded); err != nil {
+		t.Fatalf("completeStagedUpload = %d, %v", status, err)
+	}
+
+	object, status, err := openStagedUpload(ctx, store, slot)
+	if err != nil {
+		t.Fatalf("openStagedUpload = %d, %v", status, err)
+	}
+	defer object.Close()
+	got, err := io.ReadAll(object)
+	if err != nil || !bytes.Equal(got, bytes.Join(parts, nil)) {
+		t.Errorf("assembled content = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestStartDirectUploadValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user", &database.User{ID: "u1"}) })
+	router.POST("/files/direct", StartDirectUpload)
+	start := func(body any) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/files/direct", bytes.NewReader(data)))
+		return w
+	}
+	digest := strings.Repeat("ab", sha256.Size)
+
+	if w := start(gin.H{"name": "a.txt", "size": 1, "sha256": digest}); w.Code != http.StatusNotImplemented {
+		t.Errorf("without an object store = %d, want 501", w.Code)
+	}
+
+	useFakeBucket(t)
+	tests := []struct {
+		name string
+		body gin.H
+		code int
+	}{
+		{"bad name", gin.H{"name": "../a.txt", "size": 1, "sha256": digest}, http.StatusBadRequest},
+		{"bad folder", gin.H{"name": "a.txt", "folder": "/../x", "size": 1, "sha256": digest}, http.StatusBadRequest},
+		{"negative size", gin.H{"name": "a.txt", "size": -1, "sha256": digest}, http.StatusBadRequest},
+		{"too large", gin.H{"name": "a.txt", "size": upload.MaxSize + 1, "sha256": digest}, http.StatusRequestEntityTooLarge},
+		{"short digest", gin.H{"name": "a.txt", "size": 1, "sha256": "abcd"}, http.StatusBadRequest},
+		{"not hex", gin.H{"name": "a.txt", "size": 1, "sha256": strings.Repeat("zz", sha256.Size)}, http.StatusBadRequest},
+		{"lifetime too long", gin.H{"name": "a.txt", "size": 1, "sha256": digest, "expires_in": 1 << 30}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := start(tt.body); w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.code, w.Body.String())
+		}
+	}
+}
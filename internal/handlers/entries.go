@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/archive"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/throttle"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+)
+
+// GetArchiveEntries lists the members of a zip or tar archive the user can
+// download, with their total expanded size.
+func GetArchiveEntries(c *gin.Context) {
+	file, content, ok := openArchive(c)
+	if !ok {
+		return
+	}
+	defer content.Close()
+
+	r, size, err := archive.ReaderAt(content)
+	if err == nil {
+		var entries []archive.Entry
+		if entries, err = archive.List(r, size, file.MimeType, archive.Default); err == nil {
+			var total int64
+			for _, e := range entries {
+				total += e.Size
+			}
+			c.JSON(http.StatusOK, gin.H{"entries": entries, "count": len(entries), "total_size": total})
+			return
+		}
+	}
+	archiveError(c, err)
+}
+
+// GetArchiveEntry streams one file member of an archive the user can
+// download, decompressing it on the fly. It is always served as an
+// attachment, since its content was only scanned as part of the archive.
+func GetArchiveEntry(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entry name is required"})
+		return
+	}
+	file, content, ok := openArchive(c)
+	if !ok {
+		return
+	}
+	defer content.Close()
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	r, size, err := archive.ReaderAt(content)
+	if err != nil {
+		archiveError(c, err)
+		return
+	}
+	counter := &countingWriter{w: throttle.Download.Writer(ctx, user.ID, c.Writer)}
+	defer func() {
+		metrics.DownloadBytes.Add(float64(counter.n))
+		if counter.n > 0 {
+			if err := database.RecordTransfer(ctx, user.ID, 0, counter.n); err != nil {
+				slog.WarnContext(ctx, "recording download transfer failed", "error", err)
+			}
+		}
+	}()
+
+	err = archive.Read(r, size, file.MimeType, archive.Default, name, func(e archive.Entry, member io.Reader) error {
+		mimeType, _, member, err := upload.Sniff(member)
+		if err != nil {
+			return err
+		}
+		c.Header("Content-Type", mimeType)
+		c.Header("Content-Length", strconv.FormatInt(e.Size, 10))
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(e.Name)}))
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Status(http.StatusOK)
+		_, err = io.Copy(counter, member)
+		return err
+	})
+	if err != nil {
+		if c.Writer.Written() {
+			// The status is sent; cutting the body short is all that is left.
+			slog.ErrorContext(ctx, "streaming archive entry failed", "entry", name, "error", err)
+			c.Abort()
+			return
+		}
+		archiveError(c, err)
+	}
+}
+
+// ExtractArchive queues the extraction of one of the user's archives into
+// "folder", by default a folder named after the archive next to it. The
+// extracted files are new files that are each scanned like an upload.
+func ExtractArchive(c *gin.Context) {
+	file, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	if !checkScanStatus(c, file) {
+		return
+	}
+	if !archive.Supported(file.MimeType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is not a zip or tar archive"})
+		return
+	}
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	var req struct {
+		Folder *string `json:"folder"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	target := path.Join(file.Folder, archiveBase(file.Name))
+	if req.Folder != nil {
+		target = *req.Folder
+	}
+	folder, err := upload.NormalizeFolder(target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := jobs.Enqueue(ctx, worker.KindExtractArchive, user.ID, worker.ArchiveJob{
+		FileJob: worker.FileJob{FileID: file.ID, Version: file.Version},
+		Folder:  folder,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "enqueueing archive extraction failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start extraction"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job": job, "folder": folder})
+}
+
+// openArchive loads the archive named by the :id parameter, checks that the
+// user may download it and opens its content, responding with an error if
+// any of that fails.
+func openArchive(c *gin.Context) (*database.File, encryption.File, bool) {
+	fileID := c.Param("id")
+	ctx := logging.With(c.Request.Context(), slog.String("file_id", fileID))
+	c.Request = c.Request.WithContext(ctx)
+
+	file, err := getFileMetadata(ctx, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return nil, nil, false
+	}
+	user := c.MustGet("user").(*database.User)
+	if !file.IsPublic && file.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized access"})
+		return nil, nil, false
+	}
+	if !checkScanStatus(c, file) {
+		return nil, nil, false
+	}
+	if !archive.Supported(file.MimeType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is not a zip or tar archive"})
+		return nil, nil, false
+	}
+	if file.ContentMissingAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "file content is missing"})
+		return nil, nil, false
+	}
+	if file.CorruptAt != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "file content is corrupt"})
+		return nil, nil, false
+	}
+
+	content, err := openStoredFile(ctx, file)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.ErrorContext(ctx, "stored file is missing", "path", file.Path)
+		c.JSON(http.StatusGone, gin.H{"error": "file content is missing"})
+		return nil, nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "opening stored file failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return nil, nil, false
+	}
+	return file, content, true
+}
+
+// archiveError responds to a failure to read an archive.
+func archiveError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, archive.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "entry not found"})
+	case errors.Is(err, archive.ErrLimit):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		slog.WarnContext(c.Request.Context(), "reading archive failed", "error", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "failed to read archive"})
+	}
+}
+
+// archiveBase is an archive's name without its archive extension.
+func archiveBase(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip", ".gz"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
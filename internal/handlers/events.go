@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/websocket"
+)
+
+// Events upgrades to a WebSocket that pushes the user's upload, scan,
+// thumbnail and version events as they happen. A user has one event
+// connection at a time; opening another closes the previous one.
+func Events(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	if err := websocket.Serve(c.Writer, c.Request, user.ID); err != nil {
+		slog.WarnContext(c.Request.Context(), "WebSocket upgrade failed", "error", err)
+	}
+}
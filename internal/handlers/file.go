@@ -1,79 +1,46 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
 	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+	"github.com/manojkp08/22BCE11415_Backend/internal/throttle"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
 	"github.com/manojkp08/22BCE11415_Backend/internal/websocket"
+	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// func UploadFile(c *gin.Context) {
-// 	// Get user from context (set by auth middleware)
-// 	user, exists := c.Get("user")
-// 	if !exists {
-// 		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
-// 		return
-// 	}
-
-// 	// Get file from form data
-// 	file, err := c.FormFile("file")
-// 	if err != nil {
-// 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-// 		return
-// 	}
-
-// 	// Create uploads directory if not exists
-// 	if err := os.MkdirAll("uploads", os.ModePerm); err != nil {
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload directory"})
-// 		return
-// 	}
-
-// 	// Generate unique filename
-// 	fileID := uuid.New().String()
-// 	fileExt := filepath.Ext(file.Filename)
-// 	newFilename := fileID + fileExt
-// 	filePath := filepath.Join("uploads", newFilename)
-
-// 	// Save file locally
-// 	if err := c.SaveUploadedFile(file, filePath); err != nil {
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
-// 		return
-// 	}
-
-// 	// Save file metadata to database
-// 	dbFile, err := database.CreateFile(database.File{
-// 		ID:       fileID,
-// 		UserID:   user.(*database.User).ID,
-// 		Name:     file.Filename,
-// 		Path:     filePath,
-// 		Size:     file.Size,
-// 		MimeType: file.Header.Get("Content-Type"),
-// 	})
-// 	if err != nil {
-// 		// Clean up saved file if DB operation fails
-// 		os.Remove(filePath)
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file metadata"})
-// 		return
-// 	}
-
-// 	c.JSON(http.StatusOK, gin.H{
-// 		"message": "file uploaded successfully",
-// 		"file":    dbFile,
-// 		"url":     "/files/" + fileID,
-// 	})
-// }
-
+// UploadFile stores every "file" field of a multipart request. The body is
+// read as a stream and each file goes straight to storage as it arrives,
+// so uploads use constant memory and are written to disk once. A single
+// file is answered with the stored file; several are stored one after
+// another and answered together with a result per file. The "tags",
+// "metadata", "meta.<key>" and "folder" fields apply to every file and
+// must come before the first one.
 func UploadFile(c *gin.Context) {
 	// Get user from context
 	user, exists := c.Get("user")
@@ -81,113 +48,417 @@ func UploadFile(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
 		return
 	}
+	userID := user.(*database.User).ID
+
+	// Track the upload from here on so clients can poll its outcome
+	uploadID := uuid.New().String()
+	if _, err := database.CreateUpload(c.Request.Context(), database.Upload{ID: uploadID, UserID: userID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start upload"})
+		return
+	}
+	c.Header("X-Upload-ID", uploadID)
+	fail := func(status int, reason string) {
+		failUpload(c.Request.Context(), uploadID, reason)
+		c.JSON(status, gin.H{"error": reason, "upload_id": uploadID})
+	}
+
+	// Pace the body as it arrives from the network
+	c.Request.Body = throttledBody{
+		Reader: throttle.Upload.Reader(c.Request.Context(), userID, c.Request.Body),
+		Closer: c.Request.Body,
+	}
 
-	// Get file from form data
-	file, err := c.FormFile("file")
+	stream, err := newUploadStream(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	part, status, err := stream.nextFile()
+	if err == io.EOF {
+		err, status = http.ErrMissingFile, http.StatusBadRequest
+	}
+	if err != nil {
+		fail(status, err.Error())
+		return
+	}
+	labels, err := parseUploadLabels(stream.form)
+	if err != nil {
+		part.Close()
+		fail(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Create channels for concurrent processing
-	resultChan := make(chan *database.File)
-	errorChan := make(chan error)
-	done := make(chan bool)
+	// Once a file has been received, record it even if the client goes
+	// away, but keep the trace.
+	ctx := context.WithoutCancel(c.Request.Context())
 
-	// Start concurrent file processing
-	go func() {
-		// Generate file metadata
-		fileID := uuid.New().String()
-		fileExt := filepath.Ext(file.Filename)
-		newFilename := fileID + fileExt
-		filePath := filepath.Join("uploads", newFilename)
-
-		// Ensure upload directory exists
-		if err := os.MkdirAll("uploads", os.ModePerm); err != nil {
-			errorChan <- fmt.Errorf("failed to create upload directory: %w", err)
-			return
+	var results []gin.H
+	for id := uploadID; ; {
+		name := part.FileName()
+		file, status, err := receiveFile(ctx, userID, id, part, stream.fields, labels)
+		if err != nil {
+			results = append(results, gin.H{"name": name, "upload_id": id, "status": "failed", "code": status, "error": err.Error()})
+		} else {
+			results = append(results, gin.H{"name": name, "upload_id": id, "status": "completed", "code": http.StatusOK, "file": file})
 		}
 
-		// Save file to storage (local/S3)
-		if err := saveUploadedFileConcurrently(file, filePath); err != nil {
-			errorChan <- fmt.Errorf("failed to save file: %w", err)
-			return
+		part, status, err = stream.nextFile()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			results = append(results, gin.H{"status": "failed", "code": status, "error": err.Error()})
+			break
 		}
+		id = uuid.New().String()
+		if _, err := database.CreateUpload(ctx, database.Upload{ID: id, UserID: userID}); err != nil {
+			part.Close()
+			results = append(results, gin.H{"name": part.FileName(), "status": "failed", "code": http.StatusInternalServerError, "error": "failed to start upload"})
+			break
+		}
+	}
 
-		// Create file metadata
-		dbFile := database.File{
-			ID:       fileID,
-			UserID:   user.(*database.User).ID,
-			Name:     file.Filename,
-			Path:     filePath,
-			Size:     file.Size,
-			MimeType: file.Header.Get("Content-Type"),
-			IsPublic: false,
+	if len(results) > 1 {
+		status := http.StatusOK
+		for _, result := range results {
+			if result["status"] != "completed" {
+				status = http.StatusMultiStatus
+			}
 		}
+		c.JSON(status, gin.H{"results": results})
+		return
+	}
+	if result := results[0]; result["status"] != "completed" {
+		c.JSON(result["code"].(int), gin.H{
+			"error":     result["error"],
+			"status":    "failed",
+			"upload_id": uploadID,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "File upload processed successfully",
+		"status":    "completed",
+		"upload_id": uploadID,
+		"file":      results[0]["file"],
+	})
+}
+
+// receiveFile checks and stores one streamed file part of an upload
+// tracked as uploadID; fields are the form fields sent just before it. On
+// failure it returns the status to respond with.
+func receiveFile(ctx context.Context, userID, uploadID string, part *multipart.Part, fields url.Values, labels uploadLabels) (*database.File, int, error) {
+	received, status, err := checkPart(ctx, userID, part, fields)
+	if err != nil {
+		part.Close()
+		failUpload(ctx, uploadID, err.Error())
+		return nil, status, err
+	}
+	defer received.src.Close()
+	file, err := storeUpload(ctx, uploadID, userID, received, labels)
+	if err != nil {
+		return nil, storeErrorStatus(err), err
+	}
+	return file, http.StatusOK, nil
+}
 
-		// Save to database
-		createdFile, err := database.CreateFile(dbFile)
+// storeUpload encrypts and stores a checked upload, records the file with
+// its scan job and reports progress on uploadID. Failures are logged and
+// recorded on the upload before being returned.
+func storeUpload(ctx context.Context, uploadID, userID string, received *receivedUpload, labels uploadLabels) (*database.File, error) {
+	start := time.Now()
+
+	// Generate file metadata unless a slot reserved the ID
+	fileID := received.fileID
+	if fileID == "" {
+		fileID = uuid.New().String()
+	}
+	ctx = logging.With(ctx, slog.String("file_id", fileID))
+	newFilename := fileID + received.ext
+	filePath := storage.Path(newFilename)
+	failed := func(err error) (*database.File, error) {
+		slog.ErrorContext(ctx, "upload failed", "error", err)
+		failUpload(ctx, uploadID, err.Error())
+		return nil, err
+	}
+
+	// Ensure upload directory exists
+	if err := storage.EnsureDir(); err != nil {
+		return failed(fmt.Errorf("failed to create upload directory: %w", err))
+	}
+
+	// Encrypt with a fresh data key when a master key is configured
+	var dataKey, wrappedKey []byte
+	var keyID string
+	if encryption.Default != nil {
+		var err error
+		dataKey, keyID, wrappedKey, err = encryption.Default.NewDataKey(fileID)
 		if err != nil {
-			// Clean up file if DB operation fails
-			os.Remove(filePath)
-			errorChan <- fmt.Errorf("failed to save metadata: %w", err)
-			return
+			return failed(fmt.Errorf("failed to create data key: %w", err))
 		}
+	}
 
-		// Cache the file metadata
-		fileJson, _ := json.Marshal(createdFile)
-		ctx := c.Request.Context() // Use the context from the HTTP request
-		if err := cache.SetFileMetadata(ctx, fileID, string(fileJson), 24*time.Hour); err != nil {
-			log.Printf("Failed to cache file metadata: %v", err)
-		}
+	// Save file to storage (local/S3), hashing and measuring it on the way
+	hash := sha256.New()
+	counter := &countingWriter{w: hash}
+	if err := saveUploadedFileConcurrently(ctx, io.TeeReader(received.content, counter), received.size, filePath, fileID, dataKey); err != nil {
+		metrics.UploadDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		return failed(fmt.Errorf("failed to save file: %w", err))
+	}
 
-		resultChan <- createdFile
-	}()
+	// Create file metadata
+	dbFile := database.File{
+		ID:       fileID,
+		UserID:   userID,
+		Name:     received.name,
+		Path:     filePath,
+		Size:     counter.n,
+		MimeType: received.mimeType,
+		IsPublic: false,
+		// Not downloadable until the scan worker clears it
+		ScanStatus: scan.StatusPending,
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		Tags:       labels.tags,
+		Metadata:   labels.metadata,
+		Folder:     labels.folder,
+		SHA256:     hex.EncodeToString(hash.Sum(nil)),
+	}
 
-	// Handle the concurrent operations
-	go func() {
-		select {
-		case createdFile := <-resultChan:
-			// Notify client via WebSocket
-			websocket.BroadcastToUser(user.(*database.User).ID, gin.H{
-				"event": "upload_complete",
-				"file":  createdFile,
-			})
-			done <- true
-
-		case err := <-errorChan:
-			log.Printf("Upload failed: %v", err)
-			errorChan <- err
+	// Save to database along with the post-upload jobs
+	scanJob, err := jobs.New(worker.KindScanFile, userID, worker.FileJob{FileID: fileID})
+	if err != nil {
+		os.Remove(filePath)
+		return failed(err)
+	}
+	createdFile, err := database.CreateFile(ctx, dbFile, scanJob)
+	if err != nil {
+		// Clean up file if DB operation fails
+		os.Remove(filePath)
+		metrics.UploadDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		return failed(fmt.Errorf("failed to save metadata: %w", err))
+	}
+	jobs.Notify()
+	if err := database.SetUploadStatus(ctx, uploadID, database.UploadStored, "", fileID); err != nil {
+		slog.WarnContext(ctx, "recording upload status failed", "upload_id", uploadID, "error", err)
+	}
+	metrics.UploadBytes.Add(float64(counter.n))
+	metrics.UploadDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+	if err := database.RecordTransfer(ctx, userID, counter.n, 0); err != nil {
+		slog.WarnContext(ctx, "recording upload transfer failed", "error", err)
+	}
+
+	// Cache the file metadata
+	fileJson, _ := json.Marshal(createdFile)
+	if err := cache.SetFileMetadata(ctx, fileID, string(fileJson), 24*time.Hour); err != nil {
+		slog.WarnContext(ctx, "caching file metadata failed", "error", err)
+	}
+
+	// Notify client via WebSocket
+	websocket.BroadcastToUser(userID, gin.H{
+		"event":     "upload_complete",
+		"upload_id": uploadID,
+		"file":      createdFile,
+	})
+	return createdFile, nil
+}
+
+// receivedUpload is an uploaded file that passed validation. content reads
+// it from the start, including the bytes consumed by type sniffing; src
+// must be closed once it has been read. size is the size the client
+// declared, or -1 if unknown. fileID is set when a slot reserved the ID.
+type receivedUpload struct {
+	fileID   string
+	name     string
+	size     int64
+	src      io.Closer
+	content  io.Reader
+	mimeType string
+	ext      string
+}
+
+// maxFormFieldBytes bounds the combined size of an upload's form fields
+// other than files, which are held in memory.
+const maxFormFieldBytes = 1 << 20
+
+// maxPartOverhead allows for the boundary and headers around each file.
+const maxPartOverhead = 16 << 10
+
+// multipartBodyLimit bounds a multipart upload request of up to files
+// files of maxSize bytes each, plus its form fields and framing. Each file
+// is also limited to maxSize on its own as it is stored.
+func multipartBodyLimit(files int, maxSize int64) int64 {
+	return int64(files)*(maxSize+maxPartOverhead) + maxFormFieldBytes
+}
+
+// uploadStream reads a multipart upload one part at a time, so file
+// content can go from the network to storage without being buffered in
+// memory or temporary files. Form fields are collected as they are passed:
+// form holds all of them and fields those since the previous file.
+type uploadStream struct {
+	reader     *multipart.Reader
+	form       url.Values
+	fields     url.Values
+	fieldBytes int64
+	files      int
+}
+
+// newUploadStream starts reading the multipart body of c's request.
+func newUploadStream(c *gin.Context) (*uploadStream, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	return &uploadStream{reader: reader, form: url.Values{}}, nil
+}
+
+// nextFile returns the next "file" part, at most upload.MaxFiles of them,
+// after collecting the form fields before it. The part must be closed or
+// read before nextFile is called again. It returns io.EOF after the last
+// part. On failure it returns the status to respond with.
+func (s *uploadStream) nextFile() (*multipart.Part, int, error) {
+	s.fields = url.Values{}
+	for {
+		part, err := s.reader.NextPart()
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		if err != nil {
+			return nil, bodyErrorStatus(err), bodyError(err)
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			if s.files++; s.files > upload.MaxFiles {
+				part.Close()
+				return nil, http.StatusBadRequest, fmt.Errorf("at most %d files can be uploaded at once", upload.MaxFiles)
+			}
+			return part, 0, nil
 		}
-	}()
 
-	// Respond immediately while processing continues in background
-	select {
-	case <-done:
-		c.JSON(http.StatusOK, gin.H{
-			"message": "File upload processed successfully",
-			"status":  "completed",
-		})
-	case err := <-errorChan:
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":  err.Error(),
-			"status": "failed",
-		})
-	case <-time.After(2 * time.Second):
-		c.JSON(http.StatusAccepted, gin.H{
-			"message": "File upload is being processed",
-			"status":  "processing",
-		})
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes-s.fieldBytes+1))
+		part.Close()
+		if err != nil {
+			return nil, bodyErrorStatus(err), bodyError(err)
+		}
+		if s.fieldBytes += int64(len(value)); s.fieldBytes > maxFormFieldBytes {
+			return nil, http.StatusRequestEntityTooLarge, errors.New("form fields too large")
+		}
+		s.form.Add(part.FormName(), string(value))
+		s.fields.Add(part.FormName(), string(value))
+	}
+}
+
+// bodyErrorStatus is the status to respond with when reading a request
+// body fails with err.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// bodyError describes a failure to read a request body to the client.
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errors.New("request body too large")
 	}
+	return err
 }
 
-// Helper function for concurrent file saving
-func saveUploadedFileConcurrently(file *multipart.FileHeader, dst string) error {
-	src, err := file.Open()
+// storeErrorStatus is the status to respond with when storeUpload or
+// storeVersion fails with err.
+func storeErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge), errors.Is(err, upload.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, upload.ErrChecksumMismatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// checksumFields names the form fields that may carry an upload's
+// checksums instead of the part's headers.
+var checksumFields = map[string]string{
+	upload.HeaderContentMD5:     "md5",
+	upload.HeaderDigest:         "digest",
+	upload.HeaderChecksumSHA256: "sha256",
+}
+
+// checkPart checks a streamed file part's name and detected type against
+// the server-wide and user's upload policies, and arranges for its content
+// to be verified against the checksums in its headers or in the fields
+// sent before it. Its size is unknown until it has been read. On failure
+// it returns the status to respond with.
+func checkPart(ctx context.Context, userID string, part *multipart.Part, fields url.Values) (*receivedUpload, int, error) {
+	if err := upload.ValidateFilename(part.FileName()); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	sums, err := upload.ParseChecksums(func(header string) string {
+		if value := part.Header.Get(header); value != "" {
+			return value
+		}
+		return fields.Get(checksumFields[header])
+	})
 	if err != nil {
-		return err
+		return nil, http.StatusBadRequest, err
 	}
-	defer src.Close()
+	return checkContent(ctx, userID, part.FileName(), verifiedBody(part, sums))
+}
+
+// verifiedBody checks what is read from body against sums while still
+// closing the original.
+func verifiedBody(body io.ReadCloser, sums upload.Checksums) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{sums.Verify(body), body}
+}
+
+// checkContent sniffs the type of the content in src, which is called
+// name, and checks it against the server-wide and user's upload policies.
+// On failure it returns the status to respond with.
+func checkContent(ctx context.Context, userID, name string, src io.ReadCloser) (*receivedUpload, int, error) {
+	mimeType, fileExt, content, err := upload.Sniff(src)
+	if errors.Is(err, upload.ErrChecksumMismatch) {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("failed to read file")
+	}
+	userPolicy, err := database.GetUploadPolicy(ctx, userID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("failed to get upload policy")
+	}
+	err = upload.Check(mimeType, fileExt, name,
+		upload.Global,
+		upload.Policy{Allow: userPolicy.AllowedTypes, Deny: userPolicy.DeniedTypes},
+	)
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+	return &receivedUpload{name: name, size: -1, src: src, content: content, mimeType: mimeType, ext: fileExt}, 0, nil
+}
+
+// failUpload records that an upload failed with reason.
+func failUpload(ctx context.Context, uploadID, reason string) {
+	if err := database.SetUploadStatus(ctx, uploadID, database.UploadFailed, reason, ""); err != nil {
+		slog.WarnContext(ctx, "recording upload failure failed", "upload_id", uploadID, "error", err)
+	}
+}
+
+// Helper function for concurrent file saving. A non-nil dataKey encrypts
+// the stored content.
+func saveUploadedFileConcurrently(ctx context.Context, src io.Reader, size int64, dst, fileID string, dataKey []byte) (err error) {
+	_, span := tracing.Start(ctx, "storage.Write",
+		attribute.String("storage.path", dst),
+		attribute.Int64("storage.size", size),
+	)
+	defer func() { tracing.End(span, err) }()
 
 	out, err := os.Create(dst)
 	if err != nil {
@@ -199,11 +470,33 @@ func saveUploadedFileConcurrently(file *multipart.FileHeader, dst string) error
 	errChan := make(chan error, 1)
 
 	go func() {
-		_, err := io.Copy(out, src)
+		if dataKey == nil {
+			errChan <- copyUpload(out, src)
+			return
+		}
+		w, err := encryption.NewWriter(out, dataKey, fileID)
+		if err == nil {
+			err = copyUpload(w, src)
+		}
+		if err == nil {
+			err = w.Close()
+		}
 		errChan <- err
 	}()
 
-	return <-errChan
+	if err = <-errChan; err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// copyUpload copies an upload, failing if it exceeds the size limit.
+func copyUpload(dst io.Writer, src io.Reader) error {
+	n, err := io.Copy(dst, io.LimitReader(src, upload.MaxSize+1))
+	if err == nil && n > upload.MaxSize {
+		err = upload.ErrTooLarge
+	}
+	return err
 }
 
 func GetUserFiles(c *gin.Context) {
@@ -213,7 +506,13 @@ func GetUserFiles(c *gin.Context) {
 		return
 	}
 
-	files, err := database.GetFilesByUserID(user.(*database.User).ID)
+	filter, err := parseFileFilter(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	files, err := database.GetFilesByUserID(c.Request.Context(), user.(*database.User).ID, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get files"})
 		return
@@ -226,29 +525,142 @@ func GetUserFiles(c *gin.Context) {
 
 func DownloadFile(c *gin.Context) {
 	fileID := c.Param("id")
+	ctx := logging.With(c.Request.Context(), slog.String("file_id", fileID))
+	c.Request = c.Request.WithContext(ctx)
 
-	// Check cache first
-	cachedFile, err := cache.GetFileMetadata(c.Request.Context(), fileID)
-	if err == nil {
+	file, err := getFileMetadata(ctx, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+
+	// Check if file is public or belongs to requesting user
+	user := c.MustGet("user").(*database.User)
+	if !file.IsPublic && file.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized access"})
+		return
+	}
+	if !checkScanStatus(c, file) {
+		return
+	}
+
+	serveFile(c, user.ID, file)
+}
+
+// checkScanStatus rejects downloads of files that have not passed a malware
+// scan and reports whether the file may be served.
+func checkScanStatus(c *gin.Context, file *database.File) bool {
+	switch file.ScanStatus {
+	case scan.StatusClean:
+		return true
+	case scan.StatusInfected:
+		c.JSON(http.StatusForbidden, gin.H{"error": "file failed malware scan", "scan_status": file.ScanStatus})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "file has not passed malware scan", "scan_status": file.ScanStatus})
+	}
+	return false
+}
+
+// getFileMetadata reads file metadata from the cache, falling back to the
+// database and caching what it finds.
+func getFileMetadata(ctx context.Context, fileID string) (*database.File, error) {
+	if cachedFile, err := cache.GetFileMetadata(ctx, fileID); err == nil {
 		var file database.File
 		if err := json.Unmarshal([]byte(cachedFile), &file); err == nil {
-			c.JSON(http.StatusOK, file)
-			return
+			return &file, nil
 		}
 	}
 
-	file, err := database.GetFileByID(fileID)
+	file, err := database.GetFileByID(ctx, fileID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return nil, err
+	}
+
+	fileJson, _ := json.Marshal(file)
+	if err := cache.SetFileMetadata(ctx, fileID, string(fileJson), 24*time.Hour); err != nil {
+		slog.WarnContext(ctx, "caching file metadata failed", "error", err)
+	}
+	return file, nil
+}
+
+// serveFile streams file to the client, honouring Range requests, paced by
+// the download limiter and counted against the downloader's transfer usage.
+func serveFile(c *gin.Context, userID string, file *database.File) {
+	ctx := c.Request.Context()
+
+	if file.ContentMissingAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "file content is missing"})
+		return
+	}
+	if file.CorruptAt != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "file content is corrupt"})
+		return
+	}
+	content, err := openStoredFile(ctx, file)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.ErrorContext(ctx, "stored file is missing", "path", file.Path)
+		c.JSON(http.StatusGone, gin.H{"error": "file content is missing"})
 		return
 	}
+	if err != nil {
+		slog.ErrorContext(ctx, "opening stored file failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return
+	}
+	defer content.Close()
 
-	// Check if file is public or belongs to requesting user
-	user, _ := c.Get("user")
-	if !file.IsPublic && file.UserID != user.(*database.User).ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized access"})
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		slog.ErrorContext(ctx, "reading stored file info failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
 		return
 	}
 
-	c.File(file.Path)
+	if digest, err := hex.DecodeString(file.SHA256); err == nil && len(digest) == sha256.Size {
+		c.Header(upload.HeaderDigest, "sha-256="+base64.StdEncoding.EncodeToString(digest))
+	}
+	w := throttledResponseWriter{
+		ResponseWriter: c.Writer,
+		w:              throttle.Download.Writer(ctx, userID, c.Writer),
+	}
+	http.ServeContent(w, c.Request, file.Name, info.ModTime(), content)
+
+	sent := int64(max(c.Writer.Size(), 0))
+	metrics.DownloadBytes.Add(float64(sent))
+	if sent > 0 {
+		if err := database.RecordTransfer(ctx, userID, 0, sent); err != nil {
+			slog.WarnContext(ctx, "recording download transfer failed", "error", err)
+		}
+	}
+}
+
+// openStoredFile opens a file's stored content for reading, decrypting it
+// if needed. Cached metadata omits the wrapped data key, so it is fetched
+// from the database.
+func openStoredFile(ctx context.Context, file *database.File) (encryption.File, error) {
+	if file.KeyID != "" && file.WrappedKey == nil {
+		key, err := database.GetFileKey(ctx, file.ID)
+		if err != nil {
+			return nil, err
+		}
+		file.KeyID, file.WrappedKey = key.KeyID, key.WrappedKey
+	}
+	return worker.OpenContent(file)
+}
+
+// throttledBody paces reads of a request body while still closing the
+// original.
+type throttledBody struct {
+	io.Reader
+	io.Closer
+}
+
+// throttledResponseWriter routes body writes through a paced writer.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (t throttledResponseWriter) Write(p []byte) (int, error) {
+	return t.w.Write(p)
 }
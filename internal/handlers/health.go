@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/health"
+)
+
+// Healthz reports that the process is alive and serving requests.
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// Readyz reports whether every dependency needed to serve traffic is
+// available. It returns 503 while the server is shutting down.
+func Readyz(c *gin.Context) {
+	report := health.Ready(c.Request.Context(), 2*time.Second)
+
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+)
+
+// GetJobs lists the user's recent background jobs. The optional status
+// query parameter filters by status (queued, running, succeeded or dead)
+// and limit (1-100, default 50) caps the result.
+func GetJobs(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+
+	status := c.Query("status")
+	switch status {
+	case "", database.JobQueued, database.JobRunning, database.JobSucceeded, database.JobDead:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be queued, running, succeeded or dead"})
+		return
+	}
+
+	limit := 50
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+
+	list, err := database.GetJobsByUserID(c.Request.Context(), user.ID, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": list})
+}
+
+// GetJob returns one of the user's background jobs.
+func GetJob(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+
+	job, err := database.GetJobForUser(c.Request.Context(), c.Param("id"), user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// RetryJob gives one of the user's dead jobs a fresh set of attempts.
+func RetryJob(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+
+	job, err := database.RequeueDeadJob(ctx, c.Param("id"), user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := database.GetJobForUser(ctx, c.Param("id"), user.ID); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "only dead jobs can be retried"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retry job"})
+		return
+	}
+	jobs.Notify()
+
+	c.JSON(http.StatusAccepted, gin.H{"job": job})
+}
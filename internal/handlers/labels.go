@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+)
+
+// metaPrefix marks form fields and query parameters naming a metadata key,
+// as in meta.project=apollo.
+const metaPrefix = "meta."
+
+var errNotOwner = errors.New("only the owner can change a file")
+
+// uploadLabels are the tags, metadata and folder given to uploaded files.
+type uploadLabels struct {
+	tags     []string
+	metadata database.Metadata
+	folder   string
+}
+
+// parseUploadLabels reads an upload's labels from the form fields "tags"
+// (repeated or comma-separated), "metadata" (a JSON object of strings),
+// "meta.<key>", which overrides the same key in "metadata", and "folder".
+func parseUploadLabels(form url.Values) (uploadLabels, error) {
+	var labels uploadLabels
+	tags, err := upload.NormalizeTags(form["tags"])
+	if err != nil {
+		return labels, err
+	}
+
+	metadata := database.Metadata{}
+	if raw := form.Get("metadata"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+			return labels, errors.New("metadata must be a JSON object of strings")
+		}
+	}
+	for key, values := range form {
+		if name, ok := strings.CutPrefix(key, metaPrefix); ok && len(values) > 0 {
+			metadata[name] = values[0]
+		}
+	}
+	if err := upload.ValidateMetadata(metadata); err != nil {
+		return labels, err
+	}
+	folder, err := upload.NormalizeFolder(form.Get("folder"))
+	if err != nil {
+		return labels, err
+	}
+	return uploadLabels{tags: tags, metadata: metadata, folder: folder}, nil
+}
+
+// parseFileFilter reads ?tag= (repeatable, all must match),
+// ?meta.<key>=<value> and ?folder= from the query string.
+func parseFileFilter(query url.Values) (database.FileFilter, error) {
+	var filter database.FileFilter
+	tags, err := upload.NormalizeTags(query["tag"])
+	if err != nil {
+		return filter, err
+	}
+	filter.Tags = tags
+
+	for key, values := range query {
+		name, ok := strings.CutPrefix(key, metaPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = database.Metadata{}
+		}
+		filter.Metadata[name] = values[0]
+	}
+	if err := upload.ValidateMetadata(filter.Metadata); err != nil {
+		return filter, err
+	}
+	if folder, ok := query["folder"]; ok {
+		if filter.Folder, err = upload.NormalizeFolder(folder[0]); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// UpdateFile changes the tags and metadata of one of the user's files.
+// "tags" replaces the whole set, after which "add_tags" and "remove_tags"
+// apply. "metadata" is merged into the existing metadata; a null value
+// removes that key.
+func UpdateFile(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+
+	var req struct {
+		Tags       *[]string          `json:"tags"`
+		AddTags    []string           `json:"add_tags"`
+		RemoveTags []string           `json:"remove_tags"`
+		Metadata   map[string]*string `json:"metadata"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	remove, err := upload.NormalizeTags(req.RemoveTags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := database.UpdateFileLabels(c.Request.Context(), c.Param("id"), func(file *database.File) error {
+		if file.UserID != user.ID {
+			return errNotOwner
+		}
+
+		tags := file.Tags
+		if req.Tags != nil {
+			tags = *req.Tags
+		}
+		tags, err := upload.NormalizeTags(append(append([]string{}, tags...), req.AddTags...))
+		if err != nil {
+			return err
+		}
+		tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(remove, tag) })
+
+		if file.Metadata == nil {
+			file.Metadata = database.Metadata{}
+		}
+		for key, value := range req.Metadata {
+			if value == nil {
+				delete(file.Metadata, key)
+			} else {
+				file.Metadata[key] = *value
+			}
+		}
+		if err := upload.ValidateMetadata(file.Metadata); err != nil {
+			return err
+		}
+		file.Tags = tags
+		return nil
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	case errors.Is(err, errNotOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, upload.ErrInvalidLabel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update file"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file": file})
+}
+
+// GetTags lists the user's tags in use, most used first, for
+// autocompletion. prefix narrows them and limit (1-100, default 20) caps
+// how many are returned.
+func GetTags(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+
+	limit := 20
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+	prefix := strings.ToLower(strings.TrimSpace(c.Query("prefix")))
+
+	tags, err := database.GetTags(c.Request.Context(), user.ID, prefix, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get tags"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/auth"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/throttle"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+)
+
+// presignOptions are the settings shared by every presign request:
+// "expires_in" in seconds and an optional "ip" the URL is bound to.
+type presignOptions struct {
+	ExpiresIn int    `json:"expires_in"`
+	IP        string `json:"ip"`
+}
+
+// ttl is the requested lifetime, auth.DefaultPresignTTL if none was given.
+func (o presignOptions) ttl() time.Duration {
+	if o.ExpiresIn == 0 {
+		return auth.DefaultPresignTTL
+	}
+	return time.Duration(o.ExpiresIn) * time.Second
+}
+
+// validate checks the requested lifetime against auth.PresignMaxTTL.
+func (o presignOptions) validate() error {
+	if o.ExpiresIn < 0 || o.ttl() > auth.PresignMaxTTL() {
+		return errors.New("expires_in must be between 1 and " + strconv.Itoa(int(auth.PresignMaxTTL().Seconds())) + " seconds")
+	}
+	return nil
+}
+
+// PresignFile returns a URL that downloads a file the user can access
+// without an Authorization header until it expires. The URL acts as the
+// user, so it stops working if the user loses access to the file.
+func PresignFile(c *gin.Context) {
+	fileID := c.Param("id")
+	ctx := logging.With(c.Request.Context(), slog.String("file_id", fileID))
+
+	file, err := getFileMetadata(ctx, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+	user := c.MustGet("user").(*database.User)
+	if !file.IsPublic && file.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized access"})
+		return
+	}
+
+	var opts presignOptions
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	link, expires, err := presignedURL(c, http.MethodGet, "/files/"+file.ID, user.ID, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"url": link, "method": http.MethodGet, "expires_at": expires})
+}
+
+// PresignUpload reserves a file ID for a file called "name" in "folder"
+// and returns a URL that uploads its content with a single PUT, without
+// an Authorization header, until it expires.
+func PresignUpload(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	var req struct {
+		presignOptions
+		Name   string  `json:"name" binding:"required"`
+		Folder *string `json:"folder"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := upload.ValidateFilename(req.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	folder := "/"
+	if req.Folder != nil {
+		var err error
+		if folder, err = upload.NormalizeFolder(*req.Folder); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	fileID := uuid.New().String()
+	link, expires, err := presignedURL(c, http.MethodPut, "/files/"+fileID+"/content", user.ID, req.presignOptions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	slot, err := database.CreateFileSlot(ctx, database.FileSlot{
+		FileID:    fileID,
+		UserID:    user.ID,
+		Name:      req.Name,
+		Folder:    folder,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reserve file"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"slot": slot, "file_id": fileID, "url": link, "method": http.MethodPut, "expires_at": expires})
+}
+
+// UploadToSlot stores the raw request body as the content of a file slot
+// reserved with PresignUpload, verified against any checksums in the
+// Content-MD5, Digest or X-Checksum-SHA256 headers. Each slot takes one
+// upload: it is used up even if storing the content fails.
+func UploadToSlot(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	fileID := c.Param("id")
+	ctx := logging.With(c.Request.Context(), slog.String("file_id", fileID))
+	c.Request = c.Request.WithContext(ctx)
+	if _, err := uuid.Parse(fileID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload slot not found"})
+		return
+	}
+
+	slot, err := database.ClaimFileSlot(ctx, fileID, user.ID, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload slot not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to claim upload slot"})
+		return
+	}
+
+	uploadID := uuid.New().String()
+	if _, err := database.CreateUpload(ctx, database.Upload{ID: uploadID, UserID: user.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start upload"})
+		return
+	}
+	c.Header("X-Upload-ID", uploadID)
+	fail := func(status int, reason string) {
+		failUpload(ctx, uploadID, reason)
+		c.JSON(status, gin.H{"error": reason, "upload_id": uploadID})
+	}
+
+	sums, err := upload.ParseChecksums(c.GetHeader)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	body := c.Request.Body
+	received, status, err := checkContent(ctx, user.ID, slot.Name, verifiedBody(throttledBody{
+		Reader: throttle.Upload.Reader(ctx, user.ID, body),
+		Closer: body,
+	}, sums))
+	if err != nil {
+		fail(status, err.Error())
+		return
+	}
+	defer received.src.Close()
+	received.fileID = slot.FileID
+	received.size = c.Request.ContentLength
+
+	file, err := storeUpload(context.WithoutCancel(ctx), uploadID, user.ID, received, uploadLabels{folder: slot.Folder})
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": bodyError(err).Error(), "upload_id": uploadID})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"upload_id": uploadID, "file": file})
+}
+
+// presignedURL signs a request of method to path for userID and returns
+// it as an absolute URL on the host the client used, with its expiry.
+func presignedURL(c *gin.Context, method, path, userID string, opts presignOptions) (string, time.Time, error) {
+	if err := opts.validate(); err != nil {
+		return "", time.Time{}, err
+	}
+	query, expires, err := auth.Presign(method, path, userID, opts.IP, opts.ttl())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	link := url.URL{Scheme: scheme, Host: c.Request.Host, Path: path, RawQuery: query.Encode()}
+	return link.String(), expires, nil
+}
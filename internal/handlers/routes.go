@@ -1,24 +1,108 @@
 package handlers
 
 import (
-	"time"
-
 	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/config"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
 	"github.com/manojkp08/22BCE11415_Backend/pkg/middleware"
 )
 
-func SetupRoutes(router *gin.Engine) {
-	// Auth routes
-	router.GET("/auth/google/login", GoogleLoginHandler)
-	router.GET("/auth/google/callback", GoogleCallbackHandler)
+func SetupRoutes(router *gin.Engine, cfg *config.Config) error {
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return err
+	}
+	allow, err := middleware.ParsePrefixes(cfg.Access.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := middleware.ParsePrefixes(cfg.Access.Deny)
+	if err != nil {
+		return err
+	}
+
+	router.Use(metrics.Middleware())
+
+	// Health routes
+	router.GET("/healthz", Healthz)
+	router.GET("/health", Healthz)
+	router.GET("/readyz", Readyz)
+
+	// Everything below is subject to the IP allow/deny lists
+	filtered := router.Group("/", middleware.IPFilter(allow, deny))
+	filtered.GET("/metrics", metrics.Handler())
+
+	// Auth routes (public, limited per client network)
+	clientKey := middleware.SubnetKey(cfg.Limits.IPv4Prefix, cfg.Limits.IPv6Prefix)
+	anonLimit := middleware.IPRateLimit(rateLimitPolicy("anonymous", cfg.Limits.AnonymousRateLimit), clientKey)
+	callbackGuard := middleware.BruteForceGuard(lockoutPolicy("oauth_callback", cfg.Limits.Lockout), clientKey)
+
+	authRoutes := filtered.Group("/auth", anonLimit)
+	{
+		authRoutes.GET("/google/login", GoogleLoginHandler)
+		authRoutes.GET("/google/callback", callbackGuard, GoogleCallbackHandler)
+	}
 
 	// File routes (protected with JWT auth)
-	authGroup := router.Group("/")
-	// authGroup.Use(middleware.AuthMiddleware(), middleware.RateLimit(100, time.Minute))
-	authGroup.Use(middleware.AuthMiddleware(), middleware.RateLimit(100, time.Minute))
+	authGroup := filtered.Group("/")
+	apiLimit := middleware.RateLimit(rateLimitPolicy("api", cfg.Limits.RateLimit))
+	uploadLimit := middleware.RateLimit(rateLimitPolicy("upload", cfg.Limits.UploadRateLimit))
+
+	// Bearer tokens and presigned URLs are secrets too, so repeatedly
+	// presenting forged ones locks the client network out
+	credentialGuard := middleware.CredentialGuard(lockoutPolicy("credentials", cfg.Limits.Lockout), clientKey)
+	authGroup.Use(credentialGuard, middleware.PresignedAuth(), middleware.AuthMiddleware())
 	{
-		authGroup.POST("/upload", UploadFile)
-		authGroup.GET("/files", GetUserFiles)
-		authGroup.GET("/files/:id", DownloadFile)
+		authGroup.POST("/upload", uploadLimit, middleware.MaxBodySize(multipartBodyLimit(upload.MaxFiles, cfg.Limits.MaxUploadSize)), UploadFile)
+		authGroup.GET("/uploads/:id", apiLimit, GetUpload)
+		authGroup.GET("/files", apiLimit, GetUserFiles)
+		authGroup.POST("/files/batch", apiLimit, BatchFiles)
+		authGroup.POST("/files/archive", apiLimit, DownloadArchive)
+		authGroup.POST("/files/presign", apiLimit, PresignUpload)
+		authGroup.POST("/files/direct", uploadLimit, StartDirectUpload)
+		authGroup.POST("/files/direct/:id/complete", uploadLimit, CompleteDirectUpload)
+		authGroup.GET("/files/:id", apiLimit, DownloadFile)
+		authGroup.PATCH("/files/:id", apiLimit, UpdateFile)
+		authGroup.POST("/files/:id/presign", apiLimit, PresignFile)
+		authGroup.PUT("/files/:id/content", uploadLimit, middleware.MaxBodySize(cfg.Limits.MaxUploadSize), UploadToSlot)
+		authGroup.GET("/files/:id/versions", apiLimit, GetFileVersions)
+		authGroup.POST("/files/:id/versions", uploadLimit, middleware.MaxBodySize(multipartBodyLimit(1, cfg.Limits.MaxUploadSize)), UploadFileVersion)
+		authGroup.GET("/files/:id/versions/:version", apiLimit, DownloadFileVersion)
+		authGroup.POST("/files/:id/versions/:version/restore", apiLimit, RestoreFileVersion)
+		authGroup.GET("/files/:id/thumbnail", apiLimit, GetThumbnail)
+		authGroup.GET("/files/:id/entries", apiLimit, GetArchiveEntries)
+		authGroup.GET("/files/:id/entries/*name", apiLimit, GetArchiveEntry)
+		authGroup.POST("/files/:id/extract", apiLimit, ExtractArchive)
+		authGroup.GET("/search", apiLimit, Search)
+		authGroup.GET("/tags", apiLimit, GetTags)
+		authGroup.GET("/usage", apiLimit, GetUsage)
+		authGroup.GET("/upload-policy", apiLimit, GetUploadPolicy)
+		authGroup.PUT("/upload-policy", apiLimit, SetUploadPolicy)
+		authGroup.GET("/jobs", apiLimit, GetJobs)
+		authGroup.GET("/jobs/:id", apiLimit, GetJob)
+		authGroup.GET("/ws", apiLimit, Events)
+		authGroup.POST("/jobs/:id/retry", apiLimit, RetryJob)
+	}
+
+	return nil
+}
+
+func rateLimitPolicy(name string, cfg config.RateLimitConfig) middleware.RateLimitPolicy {
+	return middleware.RateLimitPolicy{
+		Name:      name,
+		Limit:     cfg.Limit,
+		Window:    cfg.Window,
+		Algorithm: middleware.Algorithm(cfg.Algorithm),
+	}
+}
+
+func lockoutPolicy(name string, cfg config.LockoutConfig) middleware.LockoutPolicy {
+	return middleware.LockoutPolicy{
+		Name:         name,
+		MaxFailures:  cfg.MaxFailures,
+		Window:       cfg.Window,
+		BaseDuration: cfg.BaseDuration,
+		MaxDuration:  cfg.MaxDuration,
+		ResetAfter:   cfg.ResetAfter,
 	}
 }
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+)
+
+const maxSearchQuery = 256
+
+var highlighter = strings.NewReplacer(
+	database.HighlightStart, "<mark>",
+	database.HighlightStop, "</mark>",
+)
+
+// Search finds the user's files, and other users' public files, whose name
+// or extracted text matches q. q uses web search syntax: words, "quoted
+// phrases", OR and -excluded words. limit (1-50, default 20) and offset
+// page through the results. Highlights are HTML-escaped text with matches
+// wrapped in <mark> tags.
+func Search(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if len(query) > maxSearchQuery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is too long"})
+		return
+	}
+
+	limit := 20
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 50 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be between 0 and 1000"})
+			return
+		}
+		offset = n
+	}
+
+	results, err := database.SearchFiles(c.Request.Context(), user.ID, query, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search files"})
+		return
+	}
+	for i := range results {
+		results[i].NameHighlight = highlight(results[i].NameHighlight)
+		results[i].Snippet = highlight(results[i].Snippet)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "limit": limit, "offset": offset})
+}
+
+// highlight escapes text and turns the database's match markers into
+// <mark> tags.
+func highlight(text string) string {
+	return highlighter.Replace(html.EscapeString(text))
+}
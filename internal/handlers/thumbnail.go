@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/thumbnail"
+	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+)
+
+// GetThumbnail serves a preview of an image file. ?size= picks one of
+// thumbnail.Sizes and defaults to medium. It returns 404 until the
+// thumbnail has been generated.
+func GetThumbnail(c *gin.Context) {
+	fileID := c.Param("id")
+	ctx := logging.With(c.Request.Context(), slog.String("file_id", fileID))
+
+	sizeName := c.DefaultQuery("size", thumbnail.DefaultSize)
+	if _, ok := thumbnail.Lookup(sizeName); !ok {
+		names := make([]string, len(thumbnail.Sizes))
+		for i, size := range thumbnail.Sizes {
+			names[i] = size.Name
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown thumbnail size", "sizes": names})
+		return
+	}
+
+	file, err := getFileMetadata(ctx, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+	user := c.MustGet("user").(*database.User)
+	if !file.IsPublic && file.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized access"})
+		return
+	}
+	if !checkScanStatus(c, file) {
+		return
+	}
+
+	thumb, err := database.GetThumbnail(ctx, file.ID, sizeName)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "thumbnail not available"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "getting thumbnail failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read thumbnail"})
+		return
+	}
+
+	if file.KeyID != "" && file.WrappedKey == nil {
+		key, err := database.GetFileKey(ctx, file.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "getting file key failed", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read thumbnail"})
+			return
+		}
+		file.KeyID, file.WrappedKey = key.KeyID, key.WrappedKey
+	}
+	content, err := encryption.OpenDerived(thumb.Path, file.ID, file.KeyID, file.WrappedKey, worker.ThumbnailPurpose(file.Version, sizeName))
+	if err != nil {
+		slog.ErrorContext(ctx, "opening thumbnail failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read thumbnail"})
+		return
+	}
+	defer content.Close()
+
+	c.Header("Content-Type", thumb.MimeType)
+	c.Header("Cache-Control", "private, max-age=86400")
+	http.ServeContent(c.Writer, c.Request, "", thumb.CreatedAt, content)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+)
+
+// GetUploadPolicy returns the user's own upload policy alongside the
+// server-wide one it is combined with.
+func GetUploadPolicy(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+
+	policy, err := database.GetUploadPolicy(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get upload policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"policy": policy,
+		"server": gin.H{
+			"allowed_types": upload.Global.Allow,
+			"denied_types":  upload.Global.Deny,
+			"max_size":      upload.MaxSize,
+		},
+	})
+}
+
+// SetUploadPolicy replaces the user's upload policy. It can only narrow
+// what the server-wide policy allows.
+func SetUploadPolicy(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+
+	var req struct {
+		AllowedTypes []string `json:"allowed_types"`
+		DeniedTypes  []string `json:"denied_types"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, entry := range append(req.AllowedTypes, req.DeniedTypes...) {
+		if err := upload.ValidatePattern(entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	policy, err := database.SetUploadPolicy(c.Request.Context(), database.UploadPolicy{
+		UserID:       user.ID,
+		AllowedTypes: append([]string{}, req.AllowedTypes...),
+		DeniedTypes:  append([]string{}, req.DeniedTypes...),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save upload policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policy": policy})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+)
+
+// maxUploadWait caps how long GET /uploads/:id long-polls.
+const maxUploadWait = 60 * time.Second
+
+// GetUpload returns the status of one of the user's uploads, with its file
+// once stored. With wait (a duration up to 60s) it long-polls: it returns
+// as soon as the status differs from status (default: the current status)
+// or is final, or when wait elapses.
+func GetUpload(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+	uploadID := c.Param("id")
+	if _, err := uuid.Parse(uploadID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return
+	}
+
+	var wait time.Duration
+	if raw := c.Query("wait"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 || d > maxUploadWait {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be a duration between 0s and 60s"})
+			return
+		}
+		wait = d
+	}
+
+	upload, err := database.GetUploadForUser(ctx, uploadID, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get upload"})
+		return
+	}
+
+	seen := c.DefaultQuery("status", upload.Status)
+	if wait > 0 && upload.Status == seen && !database.UploadFinished(upload.Status) {
+		if updated, err := waitForUpload(ctx, upload, wait); err != nil {
+			slog.WarnContext(ctx, "waiting for upload status failed", "upload_id", uploadID, "error", err)
+		} else {
+			upload = updated
+		}
+	}
+
+	response := gin.H{"upload": upload}
+	if upload.FileID != "" {
+		if file, err := database.GetFileByID(ctx, upload.FileID); err == nil {
+			response["file"] = file
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// waitForUpload blocks until the upload's status changes or wait elapses
+// and returns the upload as it then stands.
+func waitForUpload(ctx context.Context, upload *database.Upload, wait time.Duration) (*database.Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	pubsub, err := cache.Subscribe(ctx, database.UploadChannel(upload.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer pubsub.Close()
+
+	// Re-read after subscribing so a change made in between isn't missed.
+	current, err := database.GetUploadForUser(ctx, upload.ID, upload.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status != upload.Status {
+		return current, nil
+	}
+
+	select {
+	case <-pubsub.Channel():
+	case <-ctx.Done():
+	}
+	return database.GetUploadForUser(context.WithoutCancel(ctx), upload.ID, upload.UserID)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+)
+
+// GetUsage returns the user's monthly upload and download totals. The
+// optional months query parameter (1-24, default 12) sets how far back to
+// look.
+func GetUsage(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+
+	months := 12
+	if raw := c.Query("months"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 24 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "months must be between 1 and 24"})
+			return
+		}
+		months = n
+	}
+
+	usage, err := database.GetTransferUsage(c.Request.Context(), user.ID, months)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get usage"})
+		return
+	}
+
+	now := time.Now().UTC()
+	current := database.TransferUsage{
+		Period: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+	}
+	if len(usage) > 0 && usage[0].Period.Equal(current.Period) {
+		current = usage[0]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current": current,
+		"history": usage,
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -14,13 +15,17 @@ func GoogleLoginHandler(c *gin.Context) {
 
 func GoogleCallbackHandler(c *gin.Context) {
 	userInfo, err := auth.HandleGoogleCallback(c.Writer, c.Request)
+	if errors.Is(err, auth.ErrInvalidCallback) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Check if user exists in DB, if not create
-	user, err := database.GetOrCreateUser(userInfo.Email, userInfo.Name)
+	user, err := database.GetOrCreateUser(c.Request.Context(), userInfo.Email, userInfo.Name)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
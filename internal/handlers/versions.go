@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+	"github.com/manojkp08/22BCE11415_Backend/internal/throttle"
+	"github.com/manojkp08/22BCE11415_Backend/internal/websocket"
+	"github.com/manojkp08/22BCE11415_Backend/internal/worker"
+)
+
+// GetFileVersions lists the versions of one of the user's files, newest
+// first.
+func GetFileVersions(c *gin.Context) {
+	file, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+
+	versions, err := database.GetFileVersions(c.Request.Context(), file.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get versions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// UploadFileVersion stores the "file" form field as a new version of one
+// of the user's files and makes it current. Like a first upload, the new
+// version cannot be downloaded until it passes its malware scan.
+func UploadFileVersion(c *gin.Context) {
+	file, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	c.Request.Body = throttledBody{
+		Reader: throttle.Upload.Reader(ctx, user.ID, c.Request.Body),
+		Closer: c.Request.Body,
+	}
+	stream, err := newUploadStream(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	part, status, err := stream.nextFile()
+	if err == io.EOF {
+		err, status = http.ErrMissingFile, http.StatusBadRequest
+	}
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	received, status, err := checkPart(ctx, user.ID, part, stream.fields)
+	if err != nil {
+		part.Close()
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	defer received.src.Close()
+
+	version, err := database.ReserveFileVersion(ctx, file.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+	ctx = logging.With(ctx, slog.Int("version", version))
+	v, err := storeVersion(ctx, file, version, received.content, received.size, received.ext)
+	if err != nil {
+		slog.ErrorContext(ctx, "storing file version failed", "error", err)
+		c.JSON(storeErrorStatus(err), gin.H{"error": "failed to save file"})
+		return
+	}
+	// Only the rest of the body tells whether another file follows
+	if _, status, err := stream.nextFile(); err != io.EOF {
+		removeVersion(ctx, v.Path)
+		if err == nil {
+			err, status = errors.New("a version is a single file"), http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	v.MimeType = received.mimeType
+	v.UploadedBy = &user.ID
+	v.ScanStatus = scan.StatusPending
+
+	scanJob, err := jobs.New(worker.KindScanFile, user.ID, worker.FileJob{FileID: file.ID, Version: version})
+	if err != nil {
+		removeVersion(ctx, v.Path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+	updated, err := database.AddFileVersion(ctx, *v, scanJob)
+	if err != nil {
+		slog.ErrorContext(ctx, "recording file version failed", "error", err)
+		removeVersion(ctx, v.Path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save metadata"})
+		return
+	}
+	jobs.Notify()
+	versionAdded(ctx, updated, version)
+
+	metrics.UploadBytes.Add(float64(v.Size))
+	if err := database.RecordTransfer(ctx, user.ID, v.Size, 0); err != nil {
+		slog.WarnContext(ctx, "recording upload transfer failed", "error", err)
+	}
+	c.JSON(http.StatusCreated, gin.H{"file": updated, "version": v})
+}
+
+// DownloadFileVersion serves one version of one of the user's files.
+func DownloadFileVersion(c *gin.Context) {
+	file, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	v, ok := fileVersion(c, file)
+	if !ok {
+		return
+	}
+	content := versionFile(file, v)
+	if !checkScanStatus(c, content) {
+		return
+	}
+	serveFile(c, file.UserID, content)
+}
+
+// RestoreFileVersion makes an old version current again by copying it as
+// a new version, so the history keeps every state the file has been in.
+// Only versions that passed their malware scan can be restored.
+func RestoreFileVersion(c *gin.Context) {
+	file, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	v, ok := fileVersion(c, file)
+	if !ok {
+		return
+	}
+	if v.Current {
+		c.JSON(http.StatusConflict, gin.H{"error": "version is already current"})
+		return
+	}
+	old := versionFile(file, v)
+	if !checkScanStatus(c, old) {
+		return
+	}
+	user := c.MustGet("user").(*database.User)
+	ctx := c.Request.Context()
+
+	src, err := openStoredFile(ctx, old)
+	if err != nil {
+		slog.ErrorContext(ctx, "opening file version failed", "version", v.Version, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+		return
+	}
+	defer src.Close()
+
+	version, err := database.ReserveFileVersion(ctx, file.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+	ctx = logging.With(ctx, slog.Int("version", version))
+	restored, err := storeVersion(ctx, old, version, src, v.Size, filepath.Ext(v.Path))
+	if err != nil {
+		slog.ErrorContext(ctx, "storing restored version failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+		return
+	}
+	restored.MimeType = v.MimeType
+	restored.UploadedBy = &user.ID
+	restored.ScanStatus = v.ScanStatus
+
+	updated, err := database.AddFileVersion(ctx, *restored)
+	if err != nil {
+		slog.ErrorContext(ctx, "recording restored version failed", "error", err)
+		removeVersion(ctx, restored.Path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save metadata"})
+		return
+	}
+	if updated.Version == version {
+		worker.EnqueueCleanFileJobs(ctx, updated)
+	}
+	versionAdded(ctx, updated, version)
+
+	c.JSON(http.StatusOK, gin.H{"file": updated, "version": restored, "restored_from": v.Version})
+}
+
+// ownedFile loads the file named by the :id parameter and checks that it
+// belongs to the user, responding with an error if not.
+func ownedFile(c *gin.Context) (*database.File, bool) {
+	fileID := c.Param("id")
+	ctx := logging.With(c.Request.Context(), slog.String("file_id", fileID))
+	c.Request = c.Request.WithContext(ctx)
+
+	file, err := getFileMetadata(ctx, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return nil, false
+	}
+	user := c.MustGet("user").(*database.User)
+	if file.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized access"})
+		return nil, false
+	}
+	return file, true
+}
+
+// fileVersion loads the version of file named by the :version parameter.
+func fileVersion(c *gin.Context, file *database.File) (*database.FileVersion, bool) {
+	n, err := strconv.Atoi(c.Param("version"))
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a positive integer"})
+		return nil, false
+	}
+	v, err := database.GetFileVersion(c.Request.Context(), file.ID, n)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get version"})
+		return nil, false
+	}
+	return v, true
+}
+
+// versionFile returns file as it was at version v, for reading that
+// version's content.
+func versionFile(file *database.File, v *database.FileVersion) *database.File {
+	f := *file
+	f.Version = v.Version
+	f.Path = v.Path
+	f.Size = v.Size
+	f.MimeType = v.MimeType
+	f.SHA256 = v.SHA256
+	f.ScanStatus = v.ScanStatus
+	f.ContentMissingAt = nil
+	f.CorruptAt = nil
+	return &f
+}
+
+// storeVersion writes src as the given version of file, encrypted under
+// the version's derived key when the file is encrypted, and returns the
+// version's path, size and digest.
+func storeVersion(ctx context.Context, file *database.File, version int, src io.Reader, size int64, ext string) (*database.FileVersion, error) {
+	if err := storage.EnsureDir(); err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	id := file.ID
+	if file.KeyID != "" {
+		if file.WrappedKey == nil {
+			fileKey, err := database.GetFileKey(ctx, file.ID)
+			if err != nil {
+				return nil, err
+			}
+			file.KeyID, file.WrappedKey = fileKey.KeyID, fileKey.WrappedKey
+		}
+		if encryption.Default == nil {
+			return nil, encryption.ErrNoKeyring
+		}
+		dataKey, err := encryption.Default.Unwrap(file.KeyID, file.WrappedKey, file.ID)
+		if err != nil {
+			return nil, err
+		}
+		purpose := worker.VersionPurpose(version)
+		if key, err = encryption.DeriveKey(dataKey, purpose); err != nil {
+			return nil, err
+		}
+		id = encryption.DerivedID(file.ID, purpose)
+	}
+
+	path := storage.VersionPath(file.ID, version, ext)
+	hash := sha256.New()
+	counter := &countingWriter{w: hash}
+	if err := saveUploadedFileConcurrently(ctx, io.TeeReader(src, counter), size, path, id, key); err != nil {
+		return nil, err
+	}
+	return &database.FileVersion{
+		FileID:  file.ID,
+		Version: version,
+		Path:    path,
+		Size:    counter.n,
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// versionAdded drops the previous version's thumbnails, which the new
+// version replaces, and tells the owner about the new version.
+func versionAdded(ctx context.Context, file *database.File, version int) {
+	if file.Version != version {
+		// A later version was added concurrently and is current instead.
+		return
+	}
+	if err := storage.RemoveThumbnails(file.ID); err != nil {
+		slog.WarnContext(ctx, "deleting thumbnails failed", "error", err)
+	}
+	websocket.BroadcastToUser(file.UserID, gin.H{"event": "version_added", "file": file})
+}
+
+func removeVersion(ctx context.Context, path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		slog.WarnContext(ctx, "deleting stored version failed", "path", path, "error", err)
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// CheckResult is the outcome of a single dependency check.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report aggregates every readiness check.
+type Report struct {
+	Status       string                 `json:"status"`
+	ShuttingDown bool                   `json:"shutting_down,omitempty"`
+	Checks       map[string]CheckResult `json:"checks"`
+}
+
+type check func(ctx context.Context) error
+
+var checks = map[string]check{
+	"postgres": func(ctx context.Context) error {
+		return database.DB.PingContext(ctx)
+	},
+	"redis": cache.Ping,
+	"scanner": func(ctx context.Context) error {
+		return scan.Default.Ping(ctx)
+	},
+	"storage": func(ctx context.Context) error {
+		return storage.CheckWritable()
+	},
+	"migrations": func(ctx context.Context) error {
+		pending, err := database.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d migrations pending", pending)
+		}
+		return nil
+	},
+}
+
+var shuttingDown atomic.Bool
+
+// SetShuttingDown makes every subsequent readiness check fail so load
+// balancers stop routing traffic while in-flight requests drain.
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Ready runs all dependency checks concurrently, each bounded by timeout.
+func Ready(ctx context.Context, timeout time.Duration) Report {
+	report := Report{
+		Status:       StatusOK,
+		ShuttingDown: shuttingDown.Load(),
+		Checks:       make(map[string]CheckResult, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, fn := range checks {
+		wg.Add(1)
+		go func(name string, fn check) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := fn(checkCtx)
+			result := CheckResult{
+				Status:    StatusOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[name] = result
+			mu.Unlock()
+		}(name, fn)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	if report.ShuttingDown {
+		report.Status = StatusFail
+	}
+
+	return report
+}
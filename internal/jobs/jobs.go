@@ -0,0 +1,258 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Handler performs one job. Returning an error retries the job with
+// backoff until its attempts run out; wrap the error with Permanent to
+// send it straight to the dead-letter state.
+type Handler func(ctx context.Context, job *database.Job) error
+
+// Options tune the worker pool.
+type Options struct {
+	Workers      int
+	PollInterval time.Duration
+	// Lease is how long a claimed job stays locked to its worker and the
+	// timeout its handler runs with. Jobs still running after their lease
+	// are assumed lost and claimed again.
+	Lease       time.Duration
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+
+	// DefaultMaxAttempts applies to jobs enqueued without MaxAttempts.
+	DefaultMaxAttempts = 5
+
+	// wake lets Enqueue in this process start idle workers immediately.
+	wake = make(chan struct{}, 1)
+)
+
+// Register installs the handler for jobs of kind.
+func Register(kind string, h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[kind] = h
+}
+
+func handler(kind string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	h, ok := handlers[kind]
+	return h, ok
+}
+
+func kinds() []string {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	out := make([]string, 0, len(handlers))
+	for kind := range handlers {
+		out = append(out, kind)
+	}
+	return out
+}
+
+// New builds a job of kind for userID with payload encoded as JSON, ready
+// to enqueue directly or alongside a file with database.CreateFile.
+func New(kind, userID string, payload any) (database.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, fmt.Errorf("encoding %s payload: %w", kind, err)
+	}
+	return database.Job{Kind: kind, UserID: userID, Payload: data, MaxAttempts: DefaultMaxAttempts}, nil
+}
+
+// Enqueue adds a job to the queue.
+func Enqueue(ctx context.Context, kind, userID string, payload any) (*database.Job, error) {
+	job, err := New(kind, userID, payload)
+	if err != nil {
+		return nil, err
+	}
+	created, err := database.EnqueueJob(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	Notify()
+	return created, nil
+}
+
+// Notify wakes an idle worker in this process, for jobs enqueued by other
+// means such as database.CreateFile.
+func Notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Decode unmarshals a job's payload into v.
+func Decode(job *database.Job, v any) error {
+	if err := json.Unmarshal(job.Payload, v); err != nil {
+		return Permanent(fmt.Errorf("decoding %s payload: %w", job.Kind, err))
+	}
+	return nil
+}
+
+// LastAttempt reports whether a failure of job will send it to the
+// dead-letter state, so handlers can record a final outcome.
+func LastAttempt(job *database.Job) bool {
+	return job.Attempts >= job.MaxAttempts
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Run claims and runs jobs with opts.Workers workers until ctx is done,
+// then waits for jobs in progress to finish.
+func Run(ctx context.Context, opts Options) {
+	host, _ := os.Hostname()
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		worker := fmt.Sprintf("%s/%s/%d", host, uuid.NewString()[:8], i)
+		go func() {
+			defer wg.Done()
+			runWorker(ctx, worker, opts)
+		}()
+	}
+	wg.Wait()
+}
+
+func runWorker(ctx context.Context, worker string, opts Options) {
+	for {
+		buryLapsed(ctx)
+		// Drain everything due before sleeping.
+		for ctx.Err() == nil && runOne(ctx, worker, opts) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
+
+// buryLapsed dead-letters jobs whose worker died during their last
+// attempt, which are never claimed again.
+func buryLapsed(ctx context.Context) {
+	buried, err := database.BuryLapsedJobs(ctx, kinds())
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "burying lapsed jobs failed", "error", err)
+		}
+		return
+	}
+	for _, job := range buried {
+		jobCtx := logging.With(ctx, slog.String("job_id", job.ID), slog.String("job_kind", job.Kind))
+		slog.ErrorContext(jobCtx, "job failed permanently", "attempt", job.Attempts, "error", job.LastError)
+		metrics.JobsProcessed.WithLabelValues(job.Kind, "dead").Inc()
+	}
+}
+
+// runOne claims and runs a single job and reports whether one was found.
+func runOne(ctx context.Context, worker string, opts Options) bool {
+	job, err := database.ClaimJob(ctx, worker, kinds(), opts.Lease)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "claiming job failed", "error", err)
+		}
+		return false
+	}
+
+	// The job finishes even if shutdown begins; its lease bounds it.
+	jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.Lease)
+	defer cancel()
+	execute(jobCtx, worker, job, opts)
+	return true
+}
+
+func execute(ctx context.Context, worker string, job *database.Job, opts Options) {
+	ctx, span := tracing.Start(ctx, "jobs."+job.Kind,
+		attribute.String("job.id", job.ID),
+		attribute.Int("job.attempt", job.Attempts),
+	)
+	ctx = logging.With(ctx, slog.String("job_id", job.ID), slog.String("job_kind", job.Kind))
+	start := time.Now()
+
+	err := runHandler(ctx, job)
+	metrics.JobDuration.WithLabelValues(job.Kind).Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+
+	var result string
+	var serr error
+	var permanent permanentError
+	switch {
+	case err == nil:
+		result = "succeeded"
+		serr = database.CompleteJob(ctx, job.ID, worker)
+	case errors.As(err, &permanent) || LastAttempt(job):
+		result = "dead"
+		slog.ErrorContext(ctx, "job failed permanently", "attempt", job.Attempts, "error", err)
+		serr = database.BuryJob(ctx, job.ID, worker, err.Error())
+	default:
+		result = "retried"
+		delay := backoff(job.Attempts, opts.BaseBackoff, opts.MaxBackoff)
+		slog.WarnContext(ctx, "job failed, will retry", "attempt", job.Attempts, "retry_in", delay.String(), "error", err)
+		serr = database.RetryJob(ctx, job.ID, worker, time.Now().Add(delay), err.Error())
+	}
+	metrics.JobsProcessed.WithLabelValues(job.Kind, result).Inc()
+	if serr != nil {
+		slog.ErrorContext(ctx, "recording job outcome failed", "result", result, "error", serr)
+	}
+}
+
+func runHandler(ctx context.Context, job *database.Job) (err error) {
+	h, ok := handler(job.Kind)
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// backoff doubles the delay with each attempt, capped at max, with up to
+// 20% jitter so retries of a failed dependency don't arrive together.
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, max)
+	return delay - time.Duration(rand.Int64N(int64(delay)/5+1))
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fileshare"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests processed, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	UploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes written to storage by uploads.",
+	})
+
+	UploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time to store an upload and record its metadata, by result.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"result"})
+
+	DownloadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Bytes sent to clients by downloads.",
+	})
+
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "File metadata cache lookups, by result (hit, miss, error).",
+	}, []string{"result"})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter, by policy.",
+	}, []string{"policy"})
+
+	CleanupDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cleanup_run_duration_seconds",
+		Help:      "Duration of cleanup worker runs.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	CleanupFilesRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cleanup_files_removed_total",
+		Help:      "Expired files removed by the cleanup worker.",
+	})
+
+	ScanResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scan_results_total",
+		Help:      "Malware scans completed, by result (clean, infected, error).",
+	}, []string{"result"})
+
+	ScanDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scan_duration_seconds",
+		Help:      "Time to scan an upload, including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+
+	JobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_processed_total",
+		Help:      "Background job attempts, by kind and result (succeeded, retried, dead).",
+	}, []string{"kind", "result"})
+
+	JobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Time spent running one background job attempt, by kind.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, []string{"kind"})
+
+	WebSocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Currently open WebSocket connections.",
+	})
+)
+
+// RegisterDBStats exports connection pool statistics for db.
+func RegisterDBStats(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// Middleware records request counts and latency per route. Requests that
+// don't match a route are grouped under "unmatched" to bound cardinality.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+
+		HTTPRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the Prometheus exposition format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,250 @@
+// Package objectstore talks to an S3-compatible object store: it presigns
+// URLs that let clients upload to it directly and reads, completes and
+// deletes those uploads on the server's side.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxParts is the most parts a multipart upload may have.
+const MaxParts = 10000
+
+// MinPartSize is the smallest part, other than the last, that S3 accepts.
+const MinPartSize = 5 << 20
+
+// Default is the store direct uploads go to; nil disables them.
+var Default *Client
+
+// PartSize is the size of each part but the last of a multipart upload.
+var PartSize int64 = 64 << 20
+
+// ErrNotFound reports a missing object or multipart upload.
+var ErrNotFound = errors.New("object not found")
+
+// Options configures a Client.
+type Options struct {
+	// Endpoint is the store's base URL, such as https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the path rather than as a
+	// subdomain, as most self-hosted stores need.
+	PathStyle bool
+}
+
+// Client signs requests to one bucket of an S3-compatible store.
+type Client struct {
+	endpoint        *url.URL
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	pathStyle       bool
+	http            *http.Client
+}
+
+// New checks opts and returns a client for its bucket.
+func New(opts Options) (*Client, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid object store endpoint %q", opts.Endpoint)
+	}
+	switch {
+	case opts.Bucket == "":
+		return nil, errors.New("object store bucket is empty")
+	case opts.AccessKeyID == "" || opts.SecretAccessKey == "":
+		return nil, errors.New("object store credentials are empty")
+	}
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &Client{
+		endpoint:        endpoint,
+		bucket:          opts.Bucket,
+		region:          region,
+		accessKeyID:     opts.AccessKeyID,
+		secretAccessKey: opts.SecretAccessKey,
+		pathStyle:       opts.PathStyle,
+		http:            &http.Client{},
+	}, nil
+}
+
+// objectURL returns the URL of key, with query if given.
+func (c *Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if c.pathStyle {
+		path += "/" + c.bucket
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+	u.Path = path + "/" + key
+	u.RawPath = ""
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// PresignPut returns a URL that uploads the whole object key with one PUT
+// until ttl from now.
+func (c *Client) PresignPut(key string, ttl time.Duration) string {
+	return c.presign(http.MethodPut, c.objectURL(key, nil), ttl, time.Now())
+}
+
+// PresignPart returns a URL that uploads part number part of the
+// multipart upload uploadID of key until ttl from now.
+func (c *Client) PresignPart(key, uploadID string, part int, ttl time.Duration) string {
+	query := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {uploadID}}
+	return c.presign(http.MethodPut, c.objectURL(key, query), ttl, time.Now())
+}
+
+// CreateMultipartUpload starts a multipart upload of key and returns its
+// ID.
+func (c *Client) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, &result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", errors.New("object store returned no upload ID")
+	}
+	return result.UploadID, nil
+}
+
+// Part is one uploaded part of a multipart upload, as its PUT reported it.
+type Part struct {
+	Number int    `json:"part_number" xml:"PartNumber"`
+	ETag   string `json:"etag" xml:"ETag"`
+}
+
+// CompleteMultipartUpload assembles the parts of uploadID into key.
+func (c *Client) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []Part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, nil)
+}
+
+// AbortMultipartUpload discards uploadID and any parts uploaded to it.
+func (c *Client) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	err := c.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Size returns the size of object key, or ErrNotFound.
+func (c *Client) Size(ctx context.Context, key string) (int64, error) {
+	resp, err := c.send(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// Get opens object key for reading.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes object key; deleting a missing object succeeds.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// do sends a request and decodes its XML reply into result, if given.
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, body []byte, result any) error {
+	resp, err := c.send(ctx, method, key, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	// Completing a multipart upload can fail after a 200 status has been
+	// sent, with the error in the body.
+	if bytes.Contains(data, []byte("<Error>")) {
+		return responseError(resp.StatusCode, data)
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
+
+// send signs and sends a request, failing on error statuses. The caller
+// closes the response body.
+func (c *Client) send(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	c.sign(req, hashHex(body), time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return nil, responseError(resp.StatusCode, data)
+	}
+	return resp, nil
+}
+
+// responseError turns an S3 error reply into an error.
+func responseError(status int, body []byte) error {
+	var reply struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.Unmarshal(body, &reply)
+	if status == http.StatusNotFound || reply.Code == "NoSuchKey" || reply.Code == "NoSuchUpload" {
+		if reply.Code == "" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("%w: %s", ErrNotFound, reply.Code)
+	}
+	if reply.Code == "" {
+		return fmt.Errorf("object store returned status %d", status)
+	}
+	return fmt.Errorf("object store error %s: %s", reply.Code, reply.Message)
+}
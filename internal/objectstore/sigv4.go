@@ -0,0 +1,161 @@
+package objectstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as S3 and compatible stores expect it.
+const (
+	sigAlgorithm    = "AWS4-HMAC-SHA256"
+	sigService      = "s3"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+// sign adds an Authorization header to req for a body with the given hex
+// SHA-256 digest.
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	date := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           date,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		names = append(names, "content-type")
+		values["content-type"] = ct
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + strings.TrimSpace(values[name]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := c.scope(now)
+	signature := c.signature(now, stringToSign(date, scope, canonical))
+	req.Header.Set("Authorization", sigAlgorithm+" Credential="+c.accessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+// presign returns u with query parameters that authorize one request of
+// method to it until ttl from now, without any headers of the client's.
+func (c *Client) presign(method string, u *url.URL, ttl time.Duration, now time.Time) string {
+	date := now.UTC().Format(amzDateFormat)
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigAlgorithm)
+	query.Set("X-Amz-Credential", c.accessKeyID+"/"+c.scope(now))
+	query.Set("X-Amz-Date", date)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		method,
+		escapePath(u.Path),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	query.Set("X-Amz-Signature", c.signature(now, stringToSign(date, c.scope(now), canonical)))
+
+	signed := *u
+	signed.RawQuery = canonicalQuery(query)
+	return signed.String()
+}
+
+func (c *Client) scope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/" + c.region + "/" + sigService + "/aws4_request"
+}
+
+func (c *Client) signature(now time.Time, toSign string) string {
+	key := hmacSHA256([]byte("AWS4"+c.secretAccessKey), now.UTC().Format("20060102"))
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, sigService)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func stringToSign(date, scope, canonical string) string {
+	return sigAlgorithm + "\n" + date + "\n" + scope + "\n" + hashHex([]byte(canonical))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery encodes query sorted by key, escaping everything but
+// unreserved characters, as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return escape(path, false)
+}
+
+// escape percent-encodes s except for unreserved characters and, unless
+// encodeSlash is set, slashes.
+func escape(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~',
+			ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[ch>>4])
+			b.WriteByte(hexDigits[ch&15])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the largest INSTREAM chunk sent to clamd.
+const clamdChunkSize = 64 << 10
+
+// Clamd scans streams with a ClamAV daemon over its INSTREAM protocol.
+type Clamd struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamd parses addr as tcp://host:port or unix:///path/to/clamd.sock.
+// A bare host:port is treated as TCP.
+func NewClamd(addr string, timeout time.Duration) (*Clamd, error) {
+	network, address := "tcp", addr
+	if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+		network, address = scheme, rest
+	}
+	if network != "tcp" && network != "unix" {
+		return nil, fmt.Errorf("unsupported clamd network %q", network)
+	}
+	if address == "" {
+		return nil, errors.New("clamd address is empty")
+	}
+	return &Clamd{Network: network, Address: address, Timeout: timeout}, nil
+}
+
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// Ping checks that clamd is up and answering commands.
+func (c *Clamd) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return err
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd and returns its verdict.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return Result{}, err
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := w.Write(size[:]); err != nil {
+				return Result{}, err
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return Result{}, err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return Result{}, rerr
+		}
+	}
+
+	// A zero-length chunk ends the stream.
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := w.Write(size[:]); err != nil {
+		return Result{}, err
+	}
+	if err := w.Flush(); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+// parseReply interprets "stream: OK", "stream: <signature> FOUND" and
+// "<message> ERROR" replies.
+func parseReply(reply string) (Result, error) {
+	body := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case body == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(body, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(body, " FOUND")}, nil
+	case strings.HasSuffix(body, " ERROR"):
+		return Result{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(body, " ERROR"))
+	default:
+		return Result{}, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
@@ -0,0 +1,164 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the standard antivirus test signature.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers PING and INSTREAM like clamd, replying to each stream
+// with reply(content). It returns a scanner connected to it.
+func fakeClamd(t *testing.T, reply func(content []byte) string) *Clamd {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, reply)
+		}
+	}()
+
+	scanner, err := NewClamd("tcp://"+ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scanner
+}
+
+func serveClamd(conn net.Conn, reply func([]byte) string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zPING\x00":
+		io.WriteString(conn, "PONG\x00")
+	case "zINSTREAM\x00":
+		var content bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+				return
+			}
+		}
+		io.WriteString(conn, reply(content.Bytes())+"\x00")
+	default:
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+	}
+}
+
+// eicarReply flags content containing the EICAR signature, like clamd.
+func eicarReply(content []byte) string {
+	if bytes.Contains(content, []byte(eicar)) {
+		return "stream: Eicar-Signature FOUND"
+	}
+	return "stream: OK"
+}
+
+func TestClamdScan(t *testing.T) {
+	scanner := fakeClamd(t, eicarReply)
+
+	tests := []struct {
+		name    string
+		content string
+		want    Result
+	}{
+		{"clean", "hello world", Result{}},
+		{"empty", "", Result{}},
+		{"infected", "prefix " + eicar, Result{Infected: true, Signature: "Eicar-Signature"}},
+		// Larger than one INSTREAM chunk, with the signature split across chunks
+		{"infected across chunks", strings.Repeat("a", clamdChunkSize-10) + eicar, Result{Infected: true, Signature: "Eicar-Signature"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scanner.Scan(context.Background(), strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Scan = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClamdScanError(t *testing.T) {
+	scanner := fakeClamd(t, func([]byte) string { return "INSTREAM size limit exceeded. ERROR" })
+
+	_, err := scanner.Scan(context.Background(), strings.NewReader("content"))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("Scan error = %v, want clamd's error", err)
+	}
+}
+
+func TestClamdPing(t *testing.T) {
+	scanner := fakeClamd(t, eicarReply)
+	if err := scanner.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	down := &Clamd{Network: "tcp", Address: ln.Addr().String(), Timeout: time.Second}
+	if err := down.Ping(context.Background()); err == nil {
+		t.Fatal("Ping succeeded with clamd down")
+	}
+}
+
+func TestNewClamd(t *testing.T) {
+	tests := []struct {
+		addr             string
+		network, address string
+		wantErr          bool
+	}{
+		{"localhost:3310", "tcp", "localhost:3310", false},
+		{"tcp://clamav:3310", "tcp", "clamav:3310", false},
+		{"unix:///run/clamd.sock", "unix", "/run/clamd.sock", false},
+		{"udp://clamav:3310", "", "", true},
+		{"tcp://", "", "", true},
+	}
+	for _, tt := range tests {
+		c, err := NewClamd(tt.addr, time.Second)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewClamd(%q) succeeded, want an error", tt.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewClamd(%q): %v", tt.addr, err)
+			continue
+		}
+		if c.Network != tt.network || c.Address != tt.address {
+			t.Errorf("NewClamd(%q) = %s %s, want %s %s", tt.addr, c.Network, c.Address, tt.network, tt.address)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Dir is the directory uploaded files are written to.
+var Dir = "uploads"
+
+// QuarantineDir holds files that failed a malware scan. It must be on the
+// same filesystem as Dir so files can be moved by rename.
+var QuarantineDir = "quarantine"
+
+// EnsureDir creates the upload directory if it does not exist.
+func EnsureDir() error {
+	return os.MkdirAll(Dir, os.ModePerm)
+}
+
+// Path returns the on-disk location for a stored object name.
+func Path(name string) string {
+	return filepath.Join(Dir, name)
+}
+
+// CheckWritable verifies that new files can be created in the upload
+// directory by writing and removing a probe file.
+func CheckWritable() error {
+	if err := EnsureDir(); err != nil {
+		return err
+	}
+
+	probe, err := os.CreateTemp(Dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	name := probe.Name()
+	_, werr := probe.Write([]byte("ok"))
+	cerr := probe.Close()
+	os.Remove(name)
+
+	if werr != nil {
+		return werr
+	}
+	return cerr
+}
+
+// QuarantinePath returns where Quarantine moves the file at path.
+func QuarantinePath(path string) string {
+	return filepath.Join(QuarantineDir, filepath.Base(path))
+}
+
+// Quarantine moves the file at path into QuarantineDir, makes it
+// read-only and returns its new location. The location is empty if the
+// file could not be moved.
+func Quarantine(path string) (string, error) {
+	if err := os.MkdirAll(QuarantineDir, 0o700); err != nil {
+		return "", err
+	}
+	dst := QuarantinePath(path)
+	if err := os.Rename(path, dst); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dst, 0o400); err != nil {
+		return dst, err
+	}
+	return dst, nil
+}
+
+// ThumbnailPath returns the on-disk location of a file's thumbnail.
+func ThumbnailPath(fileID, size string) string {
+	return Path(fileID + ".thumb-" + size)
+}
+
+// RemoveThumbnails deletes every stored thumbnail of fileID.
+func RemoveThumbnails(fileID string) error {
+	paths, err := filepath.Glob(Path(fileID + ".thumb-*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// VersionPath returns the on-disk location of a later version of a file.
+func VersionPath(fileID string, version int, ext string) string {
+	return Path(fileID + ".v" + strconv.Itoa(version) + ext)
+}
@@ -0,0 +1,180 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// chunkSize bounds each read or write so a single call never waits for
+	// more than one bucket's worth of tokens.
+	chunkSize = 32 << 10
+
+	idleTimeout   = 10 * time.Minute
+	sweepInterval = time.Minute
+)
+
+// Limiter caps transfer rates globally and per user. Per-user buckets live
+// in this process only, so with several replicas each user gets the
+// per-user rate on every replica they reach.
+type Limiter struct {
+	global     *rate.Limiter
+	perUserBPS int64
+
+	mu        sync.Mutex
+	users     map[string]*userLimiter
+	lastSweep time.Time
+}
+
+// userLimiter is a user's bucket. lastUsed, in Unix nanoseconds, is
+// touched on every read or write so long transfers keep it from being swept.
+type userLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed atomic.Int64
+}
+
+func (u *userLimiter) touch() {
+	if u != nil {
+		u.lastUsed.Store(time.Now().UnixNano())
+	}
+}
+
+func (u *userLimiter) bucket() *rate.Limiter {
+	if u == nil {
+		return nil
+	}
+	return u.limiter
+}
+
+var (
+	Upload   = NewLimiter(0, 0)
+	Download = NewLimiter(0, 0)
+)
+
+// NewLimiter returns a limiter allowing globalBPS bytes per second across
+// all users and perUserBPS for each user. Zero disables either cap.
+func NewLimiter(globalBPS, perUserBPS int64) *Limiter {
+	return &Limiter{
+		global:     newBucket(globalBPS),
+		perUserBPS: perUserBPS,
+		users:      make(map[string]*userLimiter),
+	}
+}
+
+func newBucket(bps int64) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bps), max(int(bps), chunkSize))
+}
+
+func (l *Limiter) user(userID string) *userLimiter {
+	if l.perUserBPS <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for id, u := range l.users {
+			if now.Sub(time.Unix(0, u.lastUsed.Load())) > idleTimeout {
+				delete(l.users, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	u, ok := l.users[userID]
+	if !ok {
+		u = &userLimiter{limiter: newBucket(l.perUserBPS)}
+		l.users[userID] = u
+	}
+	u.lastUsed.Store(now.UnixNano())
+	return u
+}
+
+func wait(ctx context.Context, n int, limiters ...*rate.Limiter) error {
+	for _, lim := range limiters {
+		if lim == nil {
+			continue
+		}
+		if err := lim.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader wraps r so reads by userID are paced to the configured rates.
+func (l *Limiter) Reader(ctx context.Context, userID string, r io.Reader) io.Reader {
+	user := l.user(userID)
+	if l.global == nil && user == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, user: user, limiters: []*rate.Limiter{user.bucket(), l.global}}
+}
+
+// Writer wraps w so writes by userID are paced to the configured rates.
+func (l *Limiter) Writer(ctx context.Context, userID string, w io.Writer) io.Writer {
+	user := l.user(userID)
+	if l.global == nil && user == nil {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, user: user, limiters: []*rate.Limiter{user.bucket(), l.global}}
+}
+
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	user     *userLimiter
+	limiters []*rate.Limiter
+}
+
+func (t *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.user.touch()
+		if werr := wait(t.ctx, n, t.limiters...); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx      context.Context
+	w        io.Writer
+	user     *userLimiter
+	limiters []*rate.Limiter
+}
+
+func (t *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		t.user.touch()
+		if err := wait(t.ctx, len(chunk), t.limiters...); err != nil {
+			return written, err
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,44 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// age makes every bucket of l look idle for longer than idleTimeout and
+// lets the next lookup sweep.
+func age(l *Limiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, u := range l.users {
+		u.lastUsed.Store(time.Now().Add(-2 * idleTimeout).UnixNano())
+	}
+	l.lastSweep = time.Time{}
+}
+
+func TestActiveTransferKeepsBucket(t *testing.T) {
+	l := NewLimiter(0, 1<<30)
+	r := l.Reader(context.Background(), "busy", strings.NewReader(strings.Repeat("x", 1024)))
+	bucket := l.user("busy")
+	l.Reader(context.Background(), "idle", strings.NewReader(""))
+
+	age(l)
+	// The transfer is still running when the next sweep comes round
+	if _, err := io.ReadFull(r, make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+	l.user("someone else")
+
+	if got := l.user("busy"); got != bucket {
+		t.Error("the bucket of a running transfer was swept")
+	}
+	l.mu.Lock()
+	_, idleKept := l.users["idle"]
+	l.mu.Unlock()
+	if idleKept {
+		t.Error("an idle bucket survived the sweep")
+	}
+}
@@ -0,0 +1,133 @@
+// Package thumbnail renders scaled-down previews of uploaded images using
+// pure-Go decoders.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Size is a named bounding box thumbnails are fitted into.
+type Size struct {
+	Name   string
+	Pixels int
+}
+
+// Sizes are the thumbnails generated for every supported image.
+var Sizes = []Size{
+	{Name: "small", Pixels: 128},
+	{Name: "medium", Pixels: 256},
+	{Name: "large", Pixels: 512},
+}
+
+// DefaultSize is served when a request does not name one.
+const DefaultSize = "medium"
+
+// MaxPixels bounds the decoded size of a source image, so a small file
+// declaring huge dimensions cannot exhaust memory.
+var MaxPixels = 50_000_000
+
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+var supported = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Supported reports whether thumbnails can be generated for mimeType.
+func Supported(mimeType string) bool {
+	return supported[mimeType]
+}
+
+// Lookup returns the size called name.
+func Lookup(name string) (Size, bool) {
+	for _, size := range Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return Size{}, false
+}
+
+// Thumbnail is one encoded preview.
+type Thumbnail struct {
+	Size     string
+	MimeType string
+	Width    int
+	Height   int
+	Data     []byte
+}
+
+// Generate decodes the image in r once and renders it at every size in
+// Sizes. Images smaller than a size are not enlarged. JPEG sources produce
+// JPEG thumbnails; the other formats produce PNG to keep transparency. Only
+// the first frame of an animated GIF is used.
+func Generate(r io.ReadSeeker) ([]Thumbnail, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, errors.New("image has no pixels")
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, format, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbs := make([]Thumbnail, 0, len(Sizes))
+	for _, size := range Sizes {
+		img := scale(src, size.Pixels)
+		var buf bytes.Buffer
+		thumb := Thumbnail{Size: size.Name, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+		if format == "jpeg" {
+			thumb.MimeType = "image/jpeg"
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		} else {
+			thumb.MimeType = "image/png"
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumb.Data = buf.Bytes()
+		thumbs = append(thumbs, thumb)
+	}
+	return thumbs, nil
+}
+
+// scale fits src into a box of pixels by pixels, keeping its aspect ratio.
+func scale(src image.Image, pixels int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= pixels && h <= pixels {
+		return src
+	}
+	if w >= h {
+		h = max(1, h*pixels/w)
+		w = pixels
+	} else {
+		w = max(1, w*pixels/h)
+		h = pixels
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,151 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Headers a client may send checksums of an upload's content in.
+const (
+	// HeaderContentMD5 holds the base64 MD5 digest (RFC 1864).
+	HeaderContentMD5 = "Content-MD5"
+	// HeaderDigest holds a list of algorithm=base64 digests (RFC 3230),
+	// of which SHA-256 and MD5 are checked.
+	HeaderDigest = "Digest"
+	// HeaderChecksumSHA256 holds the SHA-256 digest in hex or base64.
+	HeaderChecksumSHA256 = "X-Checksum-SHA256"
+)
+
+var (
+	ErrInvalidChecksum  = errors.New("invalid checksum")
+	ErrChecksumMismatch = errors.New("content does not match its checksum")
+)
+
+// Checksums are the digests a client expects an upload's content to have;
+// nil digests are not checked.
+type Checksums struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+// ParseChecksums reads the checksums in the Content-MD5, Digest and
+// X-Checksum-SHA256 headers, whose values get returns. Headers that
+// disagree about a digest are an error.
+func ParseChecksums(get func(header string) string) (Checksums, error) {
+	var sums Checksums
+	set := func(dst *[]byte, sum []byte, size int, header string) error {
+		if len(sum) != size {
+			return fmt.Errorf("%w: %s has the wrong length", ErrInvalidChecksum, header)
+		}
+		if *dst != nil && !bytes.Equal(*dst, sum) {
+			return fmt.Errorf("%w: %s disagrees with another checksum", ErrInvalidChecksum, header)
+		}
+		*dst = sum
+		return nil
+	}
+
+	if value := get(HeaderContentMD5); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return sums, fmt.Errorf("%w: %s is not base64", ErrInvalidChecksum, HeaderContentMD5)
+		}
+		if err := set(&sums.MD5, sum, md5.Size, HeaderContentMD5); err != nil {
+			return sums, err
+		}
+	}
+	if value := get(HeaderDigest); value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			algorithm, encoded, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return sums, fmt.Errorf("%w: %s entries must be algorithm=value", ErrInvalidChecksum, HeaderDigest)
+			}
+			var dst *[]byte
+			var size int
+			switch strings.ToLower(algorithm) {
+			case "sha-256":
+				dst, size = &sums.SHA256, sha256.Size
+			case "md5":
+				dst, size = &sums.MD5, md5.Size
+			default:
+				// Other algorithms are allowed but not checked
+				continue
+			}
+			sum, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return sums, fmt.Errorf("%w: %s %s is not base64", ErrInvalidChecksum, HeaderDigest, algorithm)
+			}
+			if err := set(dst, sum, size, HeaderDigest); err != nil {
+				return sums, err
+			}
+		}
+	}
+	if value := strings.TrimSpace(get(HeaderChecksumSHA256)); value != "" {
+		sum, err := hex.DecodeString(value)
+		if err != nil {
+			if sum, err = base64.StdEncoding.DecodeString(value); err != nil {
+				return sums, fmt.Errorf("%w: %s is neither hex nor base64", ErrInvalidChecksum, HeaderChecksumSHA256)
+			}
+		}
+		if err := set(&sums.SHA256, sum, sha256.Size, HeaderChecksumSHA256); err != nil {
+			return sums, err
+		}
+	}
+	return sums, nil
+}
+
+// Verify returns a reader of r's content that fails with
+// ErrChecksumMismatch instead of reaching EOF if the content does not have
+// the expected digests. It returns r itself when there is nothing to check.
+func (s Checksums) Verify(r io.Reader) io.Reader {
+	if s.MD5 == nil && s.SHA256 == nil {
+		return r
+	}
+	v := &verifier{r: r, sums: s}
+	if s.MD5 != nil {
+		v.md5 = md5.New()
+	}
+	if s.SHA256 != nil {
+		v.sha256 = sha256.New()
+	}
+	return v
+}
+
+// verifier hashes what is read through it and checks the digests at EOF.
+type verifier struct {
+	r           io.Reader
+	sums        Checksums
+	md5, sha256 hash.Hash
+	err         error
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.r.Read(p)
+	for _, h := range []hash.Hash{v.md5, v.sha256} {
+		if h != nil {
+			h.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !v.matches() {
+		err = ErrChecksumMismatch
+	}
+	v.err = err
+	return n, err
+}
+
+func (v *verifier) matches() bool {
+	if v.md5 != nil && !bytes.Equal(v.md5.Sum(nil), v.sums.MD5) {
+		return false
+	}
+	return v.sha256 == nil || bytes.Equal(v.sha256.Sum(nil), v.sums.SHA256)
+}
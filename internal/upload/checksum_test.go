@@ -0,0 +1,144 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const checksumContent = "the content of an upload\n"
+
+var (
+	contentMD5    = md5.Sum([]byte(checksumContent))
+	contentSHA256 = sha256.Sum256([]byte(checksumContent))
+	otherSHA256   = sha256.Sum256([]byte("something else"))
+)
+
+func b64(sum []byte) string { return base64.StdEncoding.EncodeToString(sum) }
+
+func headers(h map[string]string) func(string) string {
+	return func(name string) string { return h[name] }
+}
+
+func TestParseChecksums(t *testing.T) {
+	md5Sum, shaSum := contentMD5[:], contentSHA256[:]
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Checksums
+	}{
+		{"none", nil, Checksums{}},
+		{"Content-MD5", map[string]string{HeaderContentMD5: b64(md5Sum)}, Checksums{MD5: md5Sum}},
+		{"Digest SHA-256", map[string]string{HeaderDigest: "SHA-256=" + b64(shaSum)}, Checksums{SHA256: shaSum}},
+		{"Digest with several algorithms", map[string]string{
+			HeaderDigest: "sha-512=AAAA, md5=" + b64(md5Sum) + ",SHA-256=" + b64(shaSum) + ", unixsum=30637",
+		}, Checksums{MD5: md5Sum, SHA256: shaSum}},
+		{"X-Checksum-SHA256 hex", map[string]string{HeaderChecksumSHA256: hex.EncodeToString(shaSum)}, Checksums{SHA256: shaSum}},
+		{"X-Checksum-SHA256 upper-case hex", map[string]string{HeaderChecksumSHA256: strings.ToUpper(hex.EncodeToString(shaSum))}, Checksums{SHA256: shaSum}},
+		{"X-Checksum-SHA256 base64", map[string]string{HeaderChecksumSHA256: b64(shaSum)}, Checksums{SHA256: shaSum}},
+		{"headers that agree", map[string]string{
+			HeaderContentMD5:     b64(md5Sum),
+			HeaderDigest:         "md5=" + b64(md5Sum) + ",sha-256=" + b64(shaSum),
+			HeaderChecksumSHA256: hex.EncodeToString(shaSum),
+		}, Checksums{MD5: md5Sum, SHA256: shaSum}},
+	}
+	for _, tt := range tests {
+		got, err := ParseChecksums(headers(tt.headers))
+		if err != nil {
+			t.Errorf("%s: ParseChecksums: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got.MD5, tt.want.MD5) || !bytes.Equal(got.SHA256, tt.want.SHA256) {
+			t.Errorf("%s: ParseChecksums = %x %x, want %x %x", tt.name, got.MD5, got.SHA256, tt.want.MD5, tt.want.SHA256)
+		}
+	}
+}
+
+func TestParseChecksumsInvalid(t *testing.T) {
+	md5Sum, shaSum := contentMD5[:], contentSHA256[:]
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"Content-MD5 not base64", map[string]string{HeaderContentMD5: "not base64!"}},
+		{"Content-MD5 of SHA-256 length", map[string]string{HeaderContentMD5: b64(shaSum)}},
+		{"Digest entry without value", map[string]string{HeaderDigest: "sha-256"}},
+		{"Digest not base64", map[string]string{HeaderDigest: "sha-256=" + hex.EncodeToString(shaSum)}},
+		{"Digest SHA-256 too short", map[string]string{HeaderDigest: "sha-256=" + b64(md5Sum)}},
+		{"Digest MD5 too long", map[string]string{HeaderDigest: "md5=" + b64(shaSum)}},
+		{"X-Checksum-SHA256 neither hex nor base64", map[string]string{HeaderChecksumSHA256: "zz!"}},
+		{"X-Checksum-SHA256 truncated", map[string]string{HeaderChecksumSHA256: hex.EncodeToString(shaSum[:31])}},
+		{"Content-MD5 and Digest disagree", map[string]string{
+			HeaderContentMD5: b64(md5Sum),
+			HeaderDigest:     "md5=" + b64(make([]byte, md5.Size)),
+		}},
+		{"Digest and X-Checksum-SHA256 disagree", map[string]string{
+			HeaderDigest:         "sha-256=" + b64(shaSum),
+			HeaderChecksumSHA256: hex.EncodeToString(otherSHA256[:]),
+		}},
+		{"Digest disagrees with itself", map[string]string{
+			HeaderDigest: "sha-256=" + b64(shaSum) + ",sha-256=" + b64(otherSHA256[:]),
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := ParseChecksums(headers(tt.headers)); !errors.Is(err, ErrInvalidChecksum) {
+			t.Errorf("%s: ParseChecksums = %v, want ErrInvalidChecksum", tt.name, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	md5Sum, shaSum := contentMD5[:], contentSHA256[:]
+	tests := []struct {
+		name string
+		sums Checksums
+		want error
+	}{
+		{"MD5", Checksums{MD5: md5Sum}, nil},
+		{"SHA-256", Checksums{SHA256: shaSum}, nil},
+		{"both", Checksums{MD5: md5Sum, SHA256: shaSum}, nil},
+		{"wrong SHA-256", Checksums{SHA256: otherSHA256[:]}, ErrChecksumMismatch},
+		{"right SHA-256, wrong MD5", Checksums{MD5: make([]byte, md5.Size), SHA256: shaSum}, ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		// One byte at a time, so the digests cover every read
+		r := tt.sums.Verify(iotest.OneByteReader(strings.NewReader(checksumContent)))
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: reading = %v, want %v", tt.name, err, tt.want)
+		}
+		if string(got) != checksumContent {
+			t.Errorf("%s: read %q, want the whole content", tt.name, got)
+		}
+		// The result sticks
+		if _, err := r.Read(make([]byte, 1)); tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: reading again = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyMismatchOnlyAtEOF(t *testing.T) {
+	sums := Checksums{SHA256: otherSHA256[:]}
+	r := sums.Verify(strings.NewReader(checksumContent))
+	buf := make([]byte, 4)
+	if n, err := r.Read(buf); n != 4 || err != nil {
+		t.Fatalf("first read = %d, %v, want the content so far", n, err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("reading to the end = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestVerifyNothingToCheck(t *testing.T) {
+	r := strings.NewReader(checksumContent)
+	if got := (Checksums{}).Verify(r); got != io.Reader(r) {
+		t.Error("Verify wrapped a reader with no checksums to check")
+	}
+}
@@ -0,0 +1,39 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Limits on folder paths.
+const (
+	MaxFolderDepth  = 16
+	MaxFolderLength = 1024
+)
+
+var ErrInvalidFolder = errors.New("invalid folder")
+
+// NormalizeFolder cleans a folder path into the form stored with files:
+// "/" for the root, otherwise "/a/b" without a trailing slash. Empty
+// segments are dropped; each remaining segment must be a valid filename.
+func NormalizeFolder(folder string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(strings.TrimSpace(folder), "/") {
+		if segment == "" {
+			continue
+		}
+		if err := ValidateFilename(segment); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidFolder, err)
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) > MaxFolderDepth {
+		return "", fmt.Errorf("%w: more than %d levels deep", ErrInvalidFolder, MaxFolderDepth)
+	}
+	normalized := "/" + strings.Join(segments, "/")
+	if len(normalized) > MaxFolderLength {
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalidFolder, MaxFolderLength)
+	}
+	return normalized, nil
+}
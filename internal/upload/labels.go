@@ -0,0 +1,65 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on the tags and metadata of one file.
+const (
+	MaxTags          = 50
+	MaxTagLength     = 64
+	MaxMetadataKeys  = 50
+	MaxMetadataValue = 1024
+)
+
+var ErrInvalidLabel = errors.New("invalid tags or metadata")
+
+var metadataKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// NormalizeTags trims and lowercases tags, splitting comma-separated
+// entries, and returns them sorted without duplicates.
+func NormalizeTags(entries []string) ([]string, error) {
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, entry := range entries {
+		for _, tag := range strings.Split(entry, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if len(tag) > MaxTagLength || !utf8.ValidString(tag) || strings.IndexFunc(tag, unicode.IsControl) >= 0 {
+				return nil, fmt.Errorf("%w: tag %q must be at most %d bytes of printable text", ErrInvalidLabel, tag, MaxTagLength)
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidLabel, MaxTags)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// ValidateMetadata checks metadata keys (letters, digits, '_', '.', '-', up
+// to 64 bytes) and values (text up to MaxMetadataValue bytes).
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataKeys {
+		return fmt.Errorf("%w: at most %d metadata keys are allowed", ErrInvalidLabel, MaxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if !metadataKey.MatchString(key) {
+			return fmt.Errorf("%w: metadata key %q must be 1-64 letters, digits, '_', '.' or '-'", ErrInvalidLabel, key)
+		}
+		if len(value) > MaxMetadataValue || !utf8.ValidString(value) || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("%w: metadata value of %q must be at most %d bytes of text", ErrInvalidLabel, key, MaxMetadataValue)
+		}
+	}
+	return nil
+}
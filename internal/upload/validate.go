@@ -0,0 +1,148 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// MaxFilenameLength is the longest filename accepted, in bytes.
+const MaxFilenameLength = 255
+
+// MaxFiles is the most files accepted in one upload request.
+const MaxFiles = 20
+
+// sniffLen is how much of the content is inspected to detect its type.
+const sniffLen = 3072
+
+var (
+	ErrInvalidFilename = errors.New("invalid filename")
+	ErrTypeNotAllowed  = errors.New("file type not allowed")
+	ErrTooLarge        = errors.New("file too large")
+)
+
+var (
+	// MaxSize is the largest file accepted, in bytes.
+	MaxSize int64 = 100 << 20
+	// Global applies to every upload, before any per-user policy.
+	Global Policy
+)
+
+// ValidateFilename rejects names that are empty, overlong, not UTF-8, or
+// that contain control characters or path components.
+func ValidateFilename(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: empty", ErrInvalidFilename)
+	case len(name) > MaxFilenameLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidFilename, MaxFilenameLength)
+	case !utf8.ValidString(name):
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidFilename)
+	case name == "." || name == "..":
+		return fmt.Errorf("%w: reserved name", ErrInvalidFilename)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("%w: contains a path separator", ErrInvalidFilename)
+	case strings.TrimSpace(name) != name:
+		return fmt.Errorf("%w: leading or trailing whitespace", ErrInvalidFilename)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return fmt.Errorf("%w: contains control characters", ErrInvalidFilename)
+		}
+	}
+	return nil
+}
+
+// Sniff detects the content type of r from its leading bytes. It returns
+// the detected MIME type, the matching file extension (which may be empty)
+// and a reader that yields the full content, including the sniffed bytes.
+func Sniff(r io.Reader) (string, string, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", "", nil, err
+	}
+	head = head[:n]
+
+	detected := mimetype.Detect(head)
+	return detected.String(), detected.Extension(), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// Policy restricts uploads by type. Entries are MIME types ("image/png"),
+// wildcard subtypes ("image/*") or extensions (".exe"). MIME entries match
+// the sniffed type; extension entries match either the uploaded name or
+// the extension of the sniffed type.
+type Policy struct {
+	Allow []string
+	Deny  []string
+}
+
+// ValidatePattern reports whether entry is a usable policy entry.
+func ValidatePattern(entry string) error {
+	if strings.HasPrefix(entry, ".") {
+		if len(entry) < 2 || strings.ContainsAny(entry, `/\ `) {
+			return fmt.Errorf("invalid extension %q", entry)
+		}
+		return nil
+	}
+	major, minor, ok := strings.Cut(entry, "/")
+	if !ok || major == "" || minor == "" || major == "*" {
+		return fmt.Errorf("invalid MIME pattern %q", entry)
+	}
+	return nil
+}
+
+func matches(pattern, mediaType string, exts []string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, ".") {
+		for _, ext := range exts {
+			if pattern == ext {
+				return true
+			}
+		}
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return pattern == mediaType
+}
+
+func matchesAny(patterns []string, mediaType string, exts []string) bool {
+	for _, pattern := range patterns {
+		if matches(pattern, mediaType, exts) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports whether a file with the sniffed MIME type and extension and
+// the given name is permitted by every policy. A deny match in any policy
+// rejects the file; a policy with a non-empty allow list must also match.
+func Check(mimeType, sniffedExt, filename string, policies ...Policy) error {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = mimeType
+	}
+	mediaType = strings.ToLower(mediaType)
+	exts := []string{strings.ToLower(path.Ext(filename)), strings.ToLower(sniffedExt)}
+
+	for _, p := range policies {
+		if matchesAny(p.Deny, mediaType, exts) {
+			return fmt.Errorf("%w: %s", ErrTypeNotAllowed, mediaType)
+		}
+		if len(p.Allow) > 0 && !matchesAny(p.Allow, mediaType, exts) {
+			return fmt.Errorf("%w: %s", ErrTypeNotAllowed, mediaType)
+		}
+	}
+	return nil
+}
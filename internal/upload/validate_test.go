@@ -0,0 +1,137 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidateFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"report.pdf", true},
+		{"résumé 2026.docx", true},
+		{".env", true},
+		{strings.Repeat("a", MaxFilenameLength), true},
+		{"", false},
+		{strings.Repeat("a", MaxFilenameLength+1), false},
+		{"bad\xffname", false},
+		{".", false},
+		{"..", false},
+		{"../etc/passwd", false},
+		{`dir\file.txt`, false},
+		{" padded.txt", false},
+		{"padded.txt ", false},
+		{"tab\there", false},
+		{"new\nline", false},
+		{"nul\x00byte", false},
+		{"bad�replacement", false},
+	}
+	for _, tt := range tests {
+		err := ValidateFilename(tt.name)
+		if tt.ok && err != nil {
+			t.Errorf("ValidateFilename(%q) = %v, want ok", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("ValidateFilename(%q) = %v, want ErrInvalidFilename", tt.name, err)
+		}
+	}
+}
+
+func TestSniff(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+	tests := []struct {
+		name     string
+		content  []byte
+		mimeType string
+		ext      string
+	}{
+		{"png", png, "image/png", ".png"},
+		{"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), "application/pdf", ".pdf"},
+		{"text", []byte("just some words\n"), "text/plain; charset=utf-8", ".txt"},
+		{"empty", nil, "text/plain", ".txt"},
+		// Longer than what is sniffed, to check nothing is lost
+		{"long", append(append([]byte{}, png...), bytes.Repeat([]byte{0}, 2*sniffLen)...), "image/png", ".png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mimeType, ext, r, err := Sniff(bytes.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("Sniff: %v", err)
+			}
+			if mimeType != tt.mimeType || ext != tt.ext {
+				t.Errorf("Sniff = %q %q, want %q %q", mimeType, ext, tt.mimeType, tt.ext)
+			}
+			content, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, tt.content) {
+				t.Errorf("content read back is %d bytes, want the %d sniffed", len(content), len(tt.content))
+			}
+		})
+	}
+}
+
+func TestSniffReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	if _, _, _, err := Sniff(io.MultiReader(strings.NewReader("abc"), errReader{readErr})); !errors.Is(err, readErr) {
+		t.Fatalf("Sniff error = %v, want %v", err, readErr)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestCheck(t *testing.T) {
+	images := Policy{Allow: []string{"image/*"}}
+	noExe := Policy{Deny: []string{".exe", "application/x-msdownload"}}
+	tests := []struct {
+		name       string
+		mimeType   string
+		sniffedExt string
+		filename   string
+		policies   []Policy
+		ok         bool
+	}{
+		{"no policies", "application/zip", ".zip", "a.zip", nil, true},
+		{"empty policy", "application/zip", ".zip", "a.zip", []Policy{{}}, true},
+		{"wildcard allow", "image/png", ".png", "cat.png", []Policy{images}, true},
+		{"wildcard allow misses", "application/pdf", ".pdf", "cat.png", []Policy{images}, false},
+		{"parameters ignored", "text/plain; charset=utf-8", ".txt", "a.txt", []Policy{{Allow: []string{"text/plain"}}}, true},
+		{"case insensitive", "Image/PNG", ".png", "CAT.PNG", []Policy{{Allow: []string{"image/png"}, Deny: []string{".PNG"}}}, false},
+		{"deny by uploaded extension", "text/plain", ".txt", "setup.exe", []Policy{noExe}, false},
+		{"deny by sniffed extension", "application/octet-stream", ".exe", "setup.txt", []Policy{noExe}, false},
+		{"deny by MIME type", "application/x-msdownload", ".dll", "lib.bin", []Policy{noExe}, false},
+		{"deny wins over allow", "image/png", ".png", "cat.png", []Policy{{Allow: []string{"image/*"}, Deny: []string{"image/png"}}}, false},
+		{"every policy must pass", "image/png", ".png", "cat.png", []Policy{images, {Allow: []string{"application/pdf"}}}, false},
+		{"user policy narrows global", "image/gif", ".gif", "a.gif", []Policy{images, {Deny: []string{"image/gif"}}}, false},
+	}
+	for _, tt := range tests {
+		err := Check(tt.mimeType, tt.sniffedExt, tt.filename, tt.policies...)
+		if tt.ok && err != nil {
+			t.Errorf("%s: Check = %v, want ok", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrTypeNotAllowed) {
+			t.Errorf("%s: Check = %v, want ErrTypeNotAllowed", tt.name, err)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, entry := range []string{"image/png", "image/*", ".exe", "application/vnd.ms-excel"} {
+		if err := ValidatePattern(entry); err != nil {
+			t.Errorf("ValidatePattern(%q) = %v, want ok", entry, err)
+		}
+	}
+	for _, entry := range []string{"", ".", ". exe", "./x", "image", "image/", "/png", "*/*"} {
+		if err := ValidatePattern(entry); err == nil {
+			t.Errorf("ValidatePattern(%q) succeeded, want an error", entry)
+		}
+	}
+}
@@ -1,24 +1,76 @@
 package websocket
 
 import (
-	"log"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
 )
 
+// writeWait bounds how long a write to one client may take, so a client
+// that stops reading cannot hold up events for itself forever.
+const writeWait = 10 * time.Second
+
 type Client struct {
 	UserID string
 	Conn   *websocket.Conn
+
+	// writeMu serializes writes, which a connection does not allow
+	// concurrently.
+	writeMu sync.Mutex
 }
 
-var Clients = make(map[string]*Client)
+var (
+	Clients   = make(map[string]*Client)
+	clientsMu sync.Mutex
+)
+
+// Register tracks a connected client, replacing any previous connection
+// for the same user.
+func Register(client *Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if old, ok := Clients[client.UserID]; ok {
+		old.Conn.Close()
+	} else {
+		metrics.WebSocketConnections.Inc()
+	}
+	Clients[client.UserID] = client
+}
 
+// Unregister closes client's connection and forgets it, unless it has
+// already been replaced by a newer connection for the same user.
+func Unregister(client *Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	client.Conn.Close()
+	if Clients[client.UserID] == client {
+		delete(Clients, client.UserID)
+		metrics.WebSocketConnections.Dec()
+	}
+}
+
+// BroadcastToUser sends message to userID's connection, if they have one.
+// The write happens outside the hub lock so a slow client only delays
+// its own events.
 func BroadcastToUser(userID string, message interface{}) {
-	if client, ok := Clients[userID]; ok {
-		if err := client.Conn.WriteJSON(message); err != nil {
-			log.Printf("WebSocket error: %v", err)
-			client.Conn.Close()
-			delete(Clients, userID)
-		}
+	clientsMu.Lock()
+	client, ok := Clients[userID]
+	clientsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	client.writeMu.Lock()
+	client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err := client.Conn.WriteJSON(message)
+	client.writeMu.Unlock()
+	if err != nil {
+		slog.Warn("writing WebSocket message failed", "user_id", userID, "error", err)
+		Unregister(client)
 	}
 }
@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxMessageSize caps what a client may send; the connection only carries
+// events to the client.
+const maxMessageSize = 512
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// Serve upgrades the request to a WebSocket connection that receives
+// userID's events, and blocks until the connection closes. On a failed
+// upgrade a response has already been written.
+func Serve(w http.ResponseWriter, r *http.Request, userID string) error {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	client := &Client{UserID: userID, Conn: conn}
+	Register(client)
+	defer Unregister(client)
+
+	// Reading is what processes close and ping frames; anything the
+	// client sends is otherwise ignored.
+	conn.SetReadLimit(maxMessageSize)
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return nil
+		}
+	}
+}
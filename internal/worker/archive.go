@@ -0,0 +1,258 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/manojkp08/22BCE11415_Backend/internal/archive"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+	"github.com/manojkp08/22BCE11415_Backend/internal/upload"
+	"github.com/manojkp08/22BCE11415_Backend/internal/websocket"
+)
+
+// KindExtractArchive unpacks an archive that passed its malware scan into
+// new files.
+const KindExtractArchive = "extract_archive"
+
+// ArchiveJob is the payload of KindExtractArchive. Folder is where the
+// archive's top level is extracted to.
+type ArchiveJob struct {
+	FileJob
+	Folder string `json:"folder"`
+}
+
+// ExtractArchive stores each file in an archive as a new file of the
+// archive's owner, under Folder and the member's own directories, and
+// scans it like an upload. Members that the upload rules would refuse, or
+// whose names escape Folder, are skipped. Archives over archive.Default's
+// limits fail permanently before anything is extracted from a zip; a tar
+// stream is refused as soon as it crosses them. Files already extracted
+// into the same place by an earlier attempt are not extracted again.
+func ExtractArchive(ctx context.Context, job *database.Job) error {
+	var payload ArchiveJob
+	if err := jobs.Decode(job, &payload); err != nil {
+		return err
+	}
+	file, err := database.GetFileByID(ctx, payload.FileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if file.ScanStatus != scan.StatusClean || !archive.Supported(file.MimeType) || payload.stale(file) {
+		return nil
+	}
+	ctx = logging.With(ctx, slog.String("file_id", file.ID), slog.String("user_id", file.UserID))
+
+	policy, err := database.GetUploadPolicy(ctx, file.UserID)
+	if err != nil {
+		return err
+	}
+	userPolicy := upload.Policy{Allow: policy.AllowedTypes, Deny: policy.DeniedTypes}
+
+	src, err := OpenContent(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	r, size, err := archive.ReaderAt(src)
+	if err != nil {
+		return err
+	}
+
+	var extracted, skipped int
+	var fnErr error
+	err = archive.Walk(r, size, file.MimeType, archive.Default, func(e archive.Entry, content io.Reader) error {
+		if e.Dir {
+			return nil
+		}
+		entryCtx := logging.With(ctx, slog.String("entry", e.Name))
+		name, ok := archive.CleanName(e.Name)
+		if !ok {
+			slog.WarnContext(entryCtx, "skipping archive entry with unsafe name")
+			skipped++
+			return nil
+		}
+		dir, base := path.Split(name)
+		folder, err := upload.NormalizeFolder(path.Join(payload.Folder, dir))
+		if err == nil {
+			err = upload.ValidateFilename(base)
+		}
+		if err == nil && e.Size > upload.MaxSize {
+			err = upload.ErrTooLarge
+		}
+		if err != nil {
+			slog.WarnContext(entryCtx, "skipping archive entry", "reason", err)
+			skipped++
+			return nil
+		}
+
+		exists, err := database.ExtractedFileExists(entryCtx, file.ID, folder, base)
+		if err != nil {
+			fnErr = err
+			return err
+		}
+		if exists {
+			return nil
+		}
+		child, err := extractEntry(entryCtx, file, folder, base, content, userPolicy)
+		if errors.Is(err, upload.ErrTypeNotAllowed) || errors.Is(err, upload.ErrTooLarge) {
+			slog.WarnContext(entryCtx, "skipping archive entry", "reason", err)
+			skipped++
+			return nil
+		}
+		if err != nil {
+			fnErr = err
+			return err
+		}
+		jobs.Notify()
+		websocket.BroadcastToUser(file.UserID, gin.H{"event": "file_extracted", "file": child})
+		extracted++
+		return nil
+	})
+	if err != nil && (fnErr == nil || err != fnErr) {
+		// The archive itself is corrupt or over its limits.
+		slog.WarnContext(ctx, "extracting archive failed", "extracted", extracted, "error", err)
+		err = jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "archive extracted", "extracted", extracted, "skipped", skipped)
+	websocket.BroadcastToUser(file.UserID, gin.H{
+		"event":     "archive_extracted",
+		"file":      file,
+		"folder":    payload.Folder,
+		"extracted": extracted,
+		"skipped":   skipped,
+	})
+	return nil
+}
+
+// extractEntry stores one archive member as a new file in folder, pending
+// its malware scan. It fails with upload.ErrTypeNotAllowed if the policies
+// refuse the member's type and upload.ErrTooLarge if it is larger than
+// upload.MaxSize. Errors reading the member are permanent.
+func extractEntry(ctx context.Context, source *database.File, folder, name string, content io.Reader, policy upload.Policy) (*database.File, error) {
+	content = &entryReader{r: io.LimitReader(content, upload.MaxSize+1)}
+	mimeType, ext, content, err := upload.Sniff(content)
+	if err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	if err := upload.Check(mimeType, ext, name, upload.Global, policy); err != nil {
+		return nil, err
+	}
+	if err := storage.EnsureDir(); err != nil {
+		return nil, err
+	}
+
+	fileID := uuid.New().String()
+	var dataKey, wrappedKey []byte
+	var keyID string
+	if encryption.Default != nil {
+		if dataKey, keyID, wrappedKey, err = encryption.Default.NewDataKey(fileID); err != nil {
+			return nil, err
+		}
+	}
+	filePath := storage.Path(fileID + ext)
+	size, digest, err := writeExtracted(filePath, fileID, content, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if size > upload.MaxSize {
+		os.Remove(filePath)
+		return nil, upload.ErrTooLarge
+	}
+
+	scanJob, err := jobs.New(KindScanFile, source.UserID, FileJob{FileID: fileID})
+	if err != nil {
+		os.Remove(filePath)
+		return nil, err
+	}
+	child, err := database.CreateFile(ctx, database.File{
+		ID:         fileID,
+		UserID:     source.UserID,
+		Name:       name,
+		Path:       filePath,
+		Size:       size,
+		MimeType:   mimeType,
+		ScanStatus: scan.StatusPending,
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		Folder:     folder,
+		SHA256:     digest,
+		SourceID:   &source.ID,
+	}, scanJob)
+	if err != nil {
+		os.Remove(filePath)
+		return nil, err
+	}
+	return child, nil
+}
+
+// writeExtracted stores src, encrypted when dataKey is set, and returns
+// its size and hex SHA-256 digest.
+func writeExtracted(path, fileID string, src io.Reader, dataKey []byte) (_ int64, _ string, err error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	var w io.Writer = out
+	var enc *encryption.Writer
+	if dataKey != nil {
+		if enc, err = encryption.NewWriter(out, dataKey, fileID); err != nil {
+			return 0, "", err
+		}
+		w = enc
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), src)
+	if err != nil {
+		return 0, "", err
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return 0, "", err
+		}
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// entryReader marks errors reading an archive member as permanent: the
+// archive is corrupt or over its limits, and retrying cannot help.
+type entryReader struct {
+	r io.Reader
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = jobs.Permanent(err)
+	}
+	return n, err
+}
@@ -1,36 +1,192 @@
 package worker
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
 	"time"
 
 	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/objectstore"
+	"github.com/manojkp08/22BCE11415_Backend/internal/schedule"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
 )
 
-func StartCleanupWorker(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// TaskCleanup names the cleanup task's lock and run history.
+const TaskCleanup = "cleanup"
 
-	for range ticker.C {
-		log.Println("Running cleanup worker...")
-		files, err := database.GetExpiredFiles()
+// CleanupOptions configures RunCleanup.
+type CleanupOptions struct {
+	// MaxAge is how long private files are kept.
+	MaxAge time.Duration
+	// JobRetention is how long succeeded jobs are kept.
+	JobRetention time.Duration
+	// KeepVersions is how many versions of each file are kept, including
+	// the current one; VersionMaxAge, when set, also prunes older ones.
+	KeepVersions  int
+	VersionMaxAge time.Duration
+	// BatchSize is the number of expired files fetched at a time.
+	BatchSize int
+	// DryRun records what would be deleted without deleting anything.
+	DryRun bool
+}
+
+// CleanupStats are the counters stored with each cleanup run.
+type CleanupStats struct {
+	Expired        int   `json:"expired"`
+	Deleted        int   `json:"deleted"`
+	Failed         int   `json:"failed"`
+	BytesFreed     int64 `json:"bytes_freed"`
+	VersionsPruned int   `json:"versions_pruned"`
+	JobsPruned     int64 `json:"jobs_pruned"`
+	UploadsFailed  int64 `json:"uploads_failed"`
+	SlotsExpired   int64 `json:"slots_expired"`
+}
+
+// StartCleanupWorker runs cleanup once at startup and then whenever sched
+// is due, until ctx is cancelled. Every replica runs the worker, but only
+// the one holding the cleanup lock does any work on each occasion.
+func StartCleanupWorker(ctx context.Context, sched schedule.Schedule, opts CleanupOptions) {
+	StartScheduled(ctx, TaskCleanup, sched, func(ctx context.Context, trigger string) error {
+		_, err := RunCleanup(ctx, trigger, opts)
+		return err
+	})
+}
+
+// RunCleanup deletes private files older than opts.MaxAge in batches, then
+// prunes old file versions and succeeded jobs, fails uploads that stopped
+// receiving, removes unused upload slots with their staged objects, and
+// records the run. It returns database.ErrLockHeld without doing anything
+// when another instance is already cleaning up.
+func RunCleanup(ctx context.Context, trigger string, opts CleanupOptions) (*database.MaintenanceRun, error) {
+	var stats CleanupStats
+	return runMaintenance(ctx, TaskCleanup, trigger, opts.DryRun, &stats, func(ctx context.Context) error {
+		start := time.Now()
+		defer func() {
+			metrics.CleanupDuration.Observe(time.Since(start).Seconds())
+		}()
+
+		if err := deleteExpiredFiles(ctx, opts, &stats); err != nil {
+			return err
+		}
+		if err := pruneVersions(ctx, opts, &stats); err != nil || opts.DryRun {
+			return err
+		}
+		var err error
+		if stats.JobsPruned, err = database.DeleteFinishedJobs(ctx, time.Now().Add(-opts.JobRetention)); err != nil {
+			return err
+		}
+		if stats.UploadsFailed, err = database.FailStaleUploads(ctx, time.Now().Add(-staleUploadAge)); err != nil {
+			return err
+		}
+		slots, err := database.DeleteExpiredFileSlots(ctx)
 		if err != nil {
-			log.Printf("Error getting expired files: %v", err)
+			return err
+		}
+		stats.SlotsExpired = int64(len(slots))
+		removeStaged(ctx, slots)
+		return nil
+	})
+}
+
+// removeStaged deletes what clients uploaded directly to the object store
+// for slots that expired before the upload was completed.
+func removeStaged(ctx context.Context, slots []database.FileSlot) {
+	for _, slot := range slots {
+		if slot.ObjectKey == "" || objectstore.Default == nil {
 			continue
 		}
+		var err error
+		if slot.MultipartID != "" {
+			err = objectstore.Default.AbortMultipartUpload(ctx, slot.ObjectKey, slot.MultipartID)
+		} else {
+			err = objectstore.Default.Delete(ctx, slot.ObjectKey)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "deleting staged upload failed", "file_id", slot.FileID, "object_key", slot.ObjectKey, "error", err)
+		}
+	}
+}
+
+// RemoveFile deletes a file with its versions and thumbnails. The row goes
+// first so the file can no longer be downloaded while its content is being
+// removed. It returns sql.ErrNoRows if the file was already deleted.
+func RemoveFile(ctx context.Context, file *database.File) error {
+	paths, err := database.GetVersionPaths(ctx, file.ID)
+	if err != nil {
+		return err
+	}
+	if err := database.DeleteFile(ctx, file.ID); err != nil {
+		return err
+	}
+	if err := removeStored(append(paths, file.Path)); err != nil {
+		return fmt.Errorf("deleting stored content: %w", err)
+	}
+	if err := storage.RemoveThumbnails(file.ID); err != nil {
+		slog.WarnContext(ctx, "deleting thumbnails failed", "error", err)
+	}
+	return nil
+}
+
+// removeStored deletes stored content, ignoring paths already gone.
+func removeStored(paths []string) error {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// staleUploadAge is how long an upload may stay receiving before its
+// request is assumed to have died.
+const staleUploadAge = time.Hour
+
+func deleteExpiredFiles(ctx context.Context, opts CleanupOptions, stats *CleanupStats) error {
+	// Fix the cutoff for the whole run so the cursor walks a stable set.
+	cutoff := time.Now().Add(-opts.MaxAge)
+	var cursor database.FileCursor
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		files, err := database.GetExpiredFiles(ctx, cutoff, cursor, opts.BatchSize)
+		if err != nil {
+			return err
+		}
 
 		for _, file := range files {
-			// Delete file from storage
-			if err := os.Remove(file.Path); err != nil {
-				log.Printf("Error deleting file %s: %v", file.ID, err)
+			stats.Expired++
+			fileCtx := logging.With(ctx, slog.String("file_id", file.ID), slog.String("user_id", file.UserID))
+			if opts.DryRun {
+				slog.InfoContext(fileCtx, "would delete expired file", "created_at", file.CreatedAt, "size", file.Size)
 				continue
 			}
 
-			// Delete from database
-			if err := database.DeleteFile(file.ID); err != nil {
-				log.Printf("Error deleting file metadata %s: %v", file.ID, err)
+			err := RemoveFile(fileCtx, &file)
+			if errors.Is(err, sql.ErrNoRows) {
+				// Deleted by its owner in the meantime.
+				continue
 			}
+			if err != nil {
+				slog.ErrorContext(fileCtx, "deleting file failed", "error", err)
+				stats.Failed++
+				continue
+			}
+			metrics.CleanupFilesRemoved.Inc()
+			stats.Deleted++
+			stats.BytesFreed += file.Size
+		}
+
+		if len(files) < opts.BatchSize {
+			return nil
 		}
+		last := files[len(files)-1]
+		cursor = database.FileCursor{CreatedAt: last.CreatedAt, ID: last.ID}
 	}
 }
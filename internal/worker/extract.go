@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/extract"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+)
+
+// KindExtractText indexes the text of a document that passed its malware
+// scan for search.
+const KindExtractText = "extract_text"
+
+// ExtractText extracts a document's text and stores it in the search
+// index. Documents that cannot be parsed fail permanently; their names
+// stay searchable.
+func ExtractText(ctx context.Context, job *database.Job) error {
+	var payload FileJob
+	if err := jobs.Decode(job, &payload); err != nil {
+		return err
+	}
+	file, err := database.GetFileByID(ctx, payload.FileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if file.ScanStatus != scan.StatusClean || !extract.Supported(file.MimeType) || payload.stale(file) {
+		return nil
+	}
+	ctx = logging.With(ctx, slog.String("file_id", file.ID), slog.String("user_id", file.UserID))
+
+	src, err := OpenContent(file)
+	if err != nil {
+		return err
+	}
+	text, err := extract.Text(src, file.MimeType)
+	src.Close()
+	if err != nil {
+		slog.WarnContext(ctx, "extracting text failed", "error", err)
+		return jobs.Permanent(err)
+	}
+
+	if err := database.SaveFileText(ctx, file.ID, text); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "text indexed", "bytes", len(text))
+	return nil
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/schedule"
+	"github.com/manojkp08/22BCE11415_Backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Run triggers recorded in the history.
+const (
+	TriggerStartup  = "startup"
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// StartScheduled calls run once at startup and then whenever sched is due,
+// until ctx is cancelled. Runs that lose the race for the task's lock to
+// another replica are not errors.
+func StartScheduled(ctx context.Context, task string, sched schedule.Schedule, run func(ctx context.Context, trigger string) error) {
+	ctx = logging.With(ctx, slog.String("worker", task))
+	trigger := TriggerStartup
+	for {
+		if err := run(ctx, trigger); err != nil && !errors.Is(err, database.ErrLockHeld) {
+			slog.ErrorContext(ctx, "maintenance run failed", "error", err)
+		}
+
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			slog.WarnContext(ctx, "schedule has no future runs")
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		trigger = TriggerSchedule
+	}
+}
+
+// runMaintenance runs fn while holding task's lock and records the run and
+// the stats fn fills in. It returns database.ErrLockHeld without calling fn
+// when another instance is running the task.
+func runMaintenance(ctx context.Context, task, trigger string, dryRun bool, stats any, fn func(ctx context.Context) error) (_ *database.MaintenanceRun, err error) {
+	ctx, span := tracing.Start(ctx, "worker."+task,
+		attribute.String("maintenance.trigger", trigger),
+		attribute.Bool("maintenance.dry_run", dryRun),
+	)
+	defer func() {
+		if errors.Is(err, database.ErrLockHeld) {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+
+	release, err := database.AcquireLock(ctx, task)
+	if errors.Is(err, database.ErrLockHeld) {
+		slog.DebugContext(ctx, "task is running on another instance", "task", task)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	host, _ := os.Hostname()
+	run, err := database.StartMaintenanceRun(ctx, database.MaintenanceRun{
+		Task:     task,
+		Trigger:  trigger,
+		Instance: host,
+		DryRun:   dryRun,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx = logging.With(ctx, slog.String("run_id", run.ID))
+	slog.InfoContext(ctx, "running maintenance task", "task", task, "trigger", trigger, "dry_run", dryRun)
+
+	err = fn(ctx)
+
+	// Record the outcome even when the run was cut short by shutdown.
+	run.Status, run.Error = database.RunSucceeded, ""
+	if err != nil {
+		run.Status, run.Error = database.RunFailed, err.Error()
+	}
+	run.Stats, _ = json.Marshal(stats)
+	finished := time.Now()
+	run.FinishedAt = &finished
+	if ferr := database.FinishMaintenanceRun(context.WithoutCancel(ctx), run.ID, run.Status, run.Stats, run.Error); ferr != nil {
+		slog.ErrorContext(ctx, "recording maintenance run failed", "error", ferr)
+	}
+	slog.InfoContext(ctx, "maintenance task finished", "task", task, "status", run.Status, "stats", string(run.Stats))
+	return run, err
+}
@@ -0,0 +1,316 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/schedule"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+)
+
+// TaskReconcile names the reconciliation task's lock and run history.
+const TaskReconcile = "reconcile"
+
+const (
+	reconcileBatchSize = 500
+	// maxReported caps the orphans and missing files listed in a run's
+	// stats; the counters are always complete.
+	maxReported = 100
+)
+
+// ReconcileOptions configures RunReconcile.
+type ReconcileOptions struct {
+	// GracePeriod protects blobs written recently, whose upload may not
+	// have created its file row yet.
+	GracePeriod time.Duration
+	// Repair deletes orphaned blobs and marks files whose content is
+	// missing. Without it a run only reports. Older versions with missing
+	// content are always only reported, as they have nothing to mark.
+	Repair bool
+}
+
+// ReconcileStats are the counters stored with each reconciliation run.
+type ReconcileStats struct {
+	FilesChecked    int      `json:"files_checked"`
+	MissingContent  int      `json:"missing_content"`
+	MarkedMissing   int      `json:"marked_missing"`
+	Restored        int      `json:"restored"`
+	VersionsChecked int      `json:"versions_checked"`
+	VersionsMissing int      `json:"versions_missing"`
+	BlobsChecked    int      `json:"blobs_checked"`
+	Orphans         int      `json:"orphans"`
+	OrphanBytes     int64    `json:"orphan_bytes"`
+	OrphansDeleted  int      `json:"orphans_deleted"`
+	Failed          int      `json:"failed"`
+	MissingFiles    []string `json:"missing_files,omitempty"`
+	MissingVersions []string `json:"missing_versions,omitempty"`
+	OrphanBlobs     []string `json:"orphan_blobs,omitempty"`
+}
+
+// StartReconcileWorker runs reconciliation once at startup and then
+// whenever sched is due, on whichever replica holds its lock.
+func StartReconcileWorker(ctx context.Context, sched schedule.Schedule, opts ReconcileOptions) {
+	StartScheduled(ctx, TaskReconcile, sched, func(ctx context.Context, trigger string) error {
+		_, _, err := RunReconcile(ctx, trigger, opts)
+		return err
+	})
+}
+
+// RunReconcile compares the files table with the storage directories. It
+// reports rows whose content is gone and blobs no row refers to, and with
+// opts.Repair marks the former and deletes the latter. It returns
+// database.ErrLockHeld when another instance is already reconciling.
+func RunReconcile(ctx context.Context, trigger string, opts ReconcileOptions) (*database.MaintenanceRun, *ReconcileStats, error) {
+	var stats ReconcileStats
+	run, err := runMaintenance(ctx, TaskReconcile, trigger, !opts.Repair, &stats, func(ctx context.Context) error {
+		if err := checkFileRows(ctx, opts, &stats); err != nil {
+			return err
+		}
+		if err := checkVersionRows(ctx, &stats); err != nil {
+			return err
+		}
+		for _, dir := range storageDirs() {
+			if err := checkBlobs(ctx, dir, opts, &stats); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return run, &stats, err
+}
+
+// storageDirs lists the directories holding stored content.
+func storageDirs() []string {
+	if filepath.Clean(storage.QuarantineDir) == filepath.Clean(storage.Dir) {
+		return []string{storage.Dir}
+	}
+	return []string{storage.Dir, storage.QuarantineDir}
+}
+
+// checkFileRows looks for file rows whose stored content is missing.
+func checkFileRows(ctx context.Context, opts ReconcileOptions, stats *ReconcileStats) error {
+	var cursor database.FileCursor
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		files, err := database.GetFilesPage(ctx, cursor, reconcileBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			stats.FilesChecked++
+			fileCtx := logging.With(ctx, slog.String("file_id", file.ID))
+			missing, err := contentMissing(fileCtx, file)
+			if err != nil {
+				slog.ErrorContext(fileCtx, "checking stored file failed", "path", file.Path, "error", err)
+				stats.Failed++
+				continue
+			}
+
+			if !missing {
+				if file.ContentMissingAt != nil {
+					stats.Restored++
+					if opts.Repair {
+						if _, err := database.SetContentMissing(fileCtx, file.ID, false); err != nil {
+							slog.ErrorContext(fileCtx, "clearing missing content mark failed", "error", err)
+							stats.Failed++
+						}
+					}
+				}
+				continue
+			}
+
+			stats.MissingContent++
+			if len(stats.MissingFiles) < maxReported {
+				stats.MissingFiles = append(stats.MissingFiles, file.ID)
+			}
+			slog.WarnContext(fileCtx, "stored content is missing", "path", file.Path)
+			if opts.Repair {
+				marked, err := database.SetContentMissing(fileCtx, file.ID, true)
+				if err != nil {
+					slog.ErrorContext(fileCtx, "marking content missing failed", "error", err)
+					stats.Failed++
+					continue
+				}
+				if marked {
+					stats.MarkedMissing++
+				}
+			}
+		}
+
+		if len(files) < reconcileBatchSize {
+			return nil
+		}
+		last := files[len(files)-1]
+		cursor = database.FileCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+}
+
+// contentMissing reports whether file's content is gone. The row is read
+// again before concluding so a file that was just quarantined, and so moved,
+// or deleted is not reported.
+func contentMissing(ctx context.Context, file database.File) (bool, error) {
+	if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+		return false, err
+	}
+	current, err := database.GetFileByID(ctx, file.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := os.Stat(current.Path); !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkVersionRows looks for older file versions whose stored content is
+// missing.
+func checkVersionRows(ctx context.Context, stats *ReconcileStats) error {
+	var cursor database.VersionCursor
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		versions, err := database.GetOlderVersionsPage(ctx, cursor, reconcileBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range versions {
+			stats.VersionsChecked++
+			versionCtx := logging.With(ctx, slog.String("file_id", v.FileID), slog.Int("version", v.Version))
+			missing, err := versionContentMissing(versionCtx, v)
+			if err != nil {
+				slog.ErrorContext(versionCtx, "checking stored version failed", "path", v.Path, "error", err)
+				stats.Failed++
+				continue
+			}
+			if !missing {
+				continue
+			}
+
+			stats.VersionsMissing++
+			if len(stats.MissingVersions) < maxReported {
+				stats.MissingVersions = append(stats.MissingVersions, v.FileID+" v"+strconv.Itoa(v.Version))
+			}
+			slog.WarnContext(versionCtx, "stored version content is missing", "path", v.Path)
+		}
+
+		if len(versions) < reconcileBatchSize {
+			return nil
+		}
+		last := versions[len(versions)-1]
+		cursor = database.VersionCursor{FileID: last.FileID, Version: last.Version}
+	}
+}
+
+// versionContentMissing is contentMissing for an older version, which may
+// have been pruned or quarantined since it was listed.
+func versionContentMissing(ctx context.Context, v database.FileVersion) (bool, error) {
+	if _, err := os.Stat(v.Path); !os.IsNotExist(err) {
+		return false, err
+	}
+	current, err := database.GetFileVersion(ctx, v.FileID, v.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := os.Stat(current.Path); !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkBlobs looks for regular files in dir that no file row refers to.
+// Blobs are matched to rows by name, so a row still counts if it was
+// written with the storage directory spelled another way. Subdirectories,
+// such as a quarantine directory inside the upload directory, and hidden
+// files are skipped.
+func checkBlobs(ctx context.Context, dir string, opts ReconcileOptions, stats *ReconcileStats) error {
+	d, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := d.ReadDir(reconcileBatchSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		sizes := make(map[string]int64, len(entries))
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				// Removed since the directory was read.
+				continue
+			}
+			stats.BlobsChecked++
+			if info.ModTime().After(cutoff) {
+				continue
+			}
+			sizes[entry.Name()] = info.Size()
+			names = append(names, entry.Name())
+		}
+		if len(names) == 0 {
+			continue
+		}
+
+		known, err := database.GetKnownNames(ctx, names)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if known[name] {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			stats.Orphans++
+			stats.OrphanBytes += sizes[name]
+			if len(stats.OrphanBlobs) < maxReported {
+				stats.OrphanBlobs = append(stats.OrphanBlobs, path)
+			}
+			slog.WarnContext(ctx, "orphaned blob", "path", path, "size", sizes[name])
+			if opts.Repair {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					slog.ErrorContext(ctx, "deleting orphaned blob failed", "path", path, "error", err)
+					stats.Failed++
+					continue
+				}
+				stats.OrphansDeleted++
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/extract"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+	"github.com/manojkp08/22BCE11415_Backend/internal/thumbnail"
+	"github.com/manojkp08/22BCE11415_Backend/internal/websocket"
+)
+
+// KindScanFile is the job that scans a newly stored upload for malware.
+const KindScanFile = "scan_file"
+
+// FileJob is the payload of jobs that act on one file. Version, when set,
+// is the version the job was enqueued for; the job does nothing once the
+// file has moved on to another version.
+type FileJob struct {
+	FileID  string `json:"file_id"`
+	Version int    `json:"version,omitempty"`
+}
+
+// stale reports whether a job was enqueued for a version of file that is
+// no longer current.
+func (j FileJob) stale(file *database.File) bool {
+	return j.Version != 0 && j.Version != file.Version
+}
+
+// ScanFile scans a pending file with scan.Default, quarantining it if
+// infected. Scanner errors are retried by the queue; once attempts run out
+// the file is marked scan_failed and stays blocked. The scan is the last
+// step of an upload, so it also moves the upload to its final status.
+func ScanFile(ctx context.Context, job *database.Job) error {
+	var payload FileJob
+	if err := jobs.Decode(job, &payload); err != nil {
+		return err
+	}
+	file, err := database.GetFileByID(ctx, payload.FileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if file.ScanStatus != scan.StatusPending || payload.stale(file) {
+		return nil
+	}
+
+	ctx = logging.With(ctx, slog.String("file_id", file.ID), slog.String("user_id", file.UserID))
+	setUploadStatus(ctx, file, database.UploadProcessing, "")
+	start := time.Now()
+	result, err := scanStoredFile(ctx, *file)
+	metrics.ScanDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.ScanResults.WithLabelValues("error").Inc()
+		if jobs.LastAttempt(job) {
+			if serr := database.SetScanResult(ctx, file.ID, file.Version, scan.StatusFailed, "", file.Path); serr != nil {
+				slog.ErrorContext(ctx, "recording scan failure failed", "error", serr)
+			}
+			file.ScanStatus = scan.StatusFailed
+			setUploadStatus(ctx, file, database.UploadFailed, "malware scan failed: "+err.Error())
+			websocket.BroadcastToUser(file.UserID, gin.H{"event": "scan_failed", "file": file})
+		}
+		return err
+	}
+
+	file.ScanStatus = scan.StatusClean
+	if result.Infected {
+		file.ScanStatus = scan.StatusInfected
+		file.ScanSignature = result.Signature
+		slog.WarnContext(ctx, "malware found in upload", "signature", result.Signature)
+	}
+	metrics.ScanResults.WithLabelValues(file.ScanStatus).Inc()
+
+	record := func(path string) error {
+		return database.SetScanResult(ctx, file.ID, file.Version, file.ScanStatus, file.ScanSignature, path)
+	}
+	if result.Infected {
+		err = quarantineFile(ctx, file, record)
+	} else {
+		err = record(file.Path)
+	}
+	if err != nil {
+		return err
+	}
+	if result.Infected {
+		setUploadStatus(ctx, file, database.UploadFailed, "malware detected: "+result.Signature)
+	} else {
+		setUploadStatus(ctx, file, database.UploadComplete, "")
+		EnqueueCleanFileJobs(ctx, file)
+	}
+	websocket.BroadcastToUser(file.UserID, gin.H{"event": "scan_complete", "file": file})
+	return nil
+}
+
+// quarantineFile moves an infected file's content into quarantine. The
+// verdict is recorded, with the quarantine path, before the content moves:
+// if recording fails nothing has moved, and the retried job finds the
+// content where the file row still says it is.
+func quarantineFile(ctx context.Context, file *database.File, record func(path string) error) error {
+	if err := record(storage.QuarantinePath(file.Path)); err != nil {
+		return err
+	}
+	path, err := storage.Quarantine(file.Path)
+	if path == "" {
+		// The content stayed put, so point the row back at it
+		slog.ErrorContext(ctx, "quarantining file failed", "error", err)
+		return record(file.Path)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "making quarantined file read-only failed", "error", err)
+	}
+	file.Path = path
+	return nil
+}
+
+// EnqueueCleanFileJobs starts the processing that only runs on files that
+// passed their scan.
+func EnqueueCleanFileJobs(ctx context.Context, file *database.File) {
+	var kinds []string
+	if thumbnail.Supported(file.MimeType) {
+		kinds = append(kinds, KindGenerateThumbnails)
+	}
+	if extract.Supported(file.MimeType) {
+		kinds = append(kinds, KindExtractText)
+	}
+	for _, kind := range kinds {
+		if _, err := jobs.Enqueue(ctx, kind, file.UserID, FileJob{FileID: file.ID, Version: file.Version}); err != nil {
+			slog.ErrorContext(ctx, "enqueueing job failed", "kind", kind, "error", err)
+		}
+	}
+}
+
+// setUploadStatus moves the upload that created file along. Later versions
+// are not tracked as uploads.
+func setUploadStatus(ctx context.Context, file *database.File, status, reason string) {
+	if file.Version > 1 {
+		return
+	}
+	if err := database.SetUploadStatusByFile(ctx, file.ID, status, reason); err != nil {
+		slog.WarnContext(ctx, "recording upload status failed", "status", status, "error", err)
+	}
+}
+
+func scanStoredFile(ctx context.Context, file database.File) (scan.Result, error) {
+	f, err := OpenContent(&file)
+	if err != nil {
+		return scan.Result{}, err
+	}
+	defer f.Close()
+
+	return scan.Default.Scan(ctx, f)
+}
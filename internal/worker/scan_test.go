@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+)
+
+// signatureScanner flags content containing signature, reading it all the
+// way the real scanners do.
+type signatureScanner struct {
+	signature string
+}
+
+func (s signatureScanner) Scan(ctx context.Context, r io.Reader) (scan.Result, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return scan.Result{}, err
+	}
+	if strings.Contains(string(content), s.signature) {
+		return scan.Result{Infected: true, Signature: "Test-Signature"}, nil
+	}
+	return scan.Result{}, nil
+}
+
+func (signatureScanner) Ping(ctx context.Context) error { return nil }
+
+// useScanner points scan.Default at scanner for the test.
+func useScanner(t *testing.T, scanner scan.Scanner) {
+	t.Helper()
+	previous := scan.Default
+	scan.Default = scanner
+	t.Cleanup(func() { scan.Default = previous })
+}
+
+// useTempStorage points the upload and quarantine directories at a
+// temporary directory.
+func useTempStorage(t *testing.T) {
+	t.Helper()
+	dir, quarantine := storage.Dir, storage.QuarantineDir
+	storage.Dir = filepath.Join(t.TempDir(), "uploads")
+	storage.QuarantineDir = filepath.Join(t.TempDir(), "quarantine")
+	t.Cleanup(func() { storage.Dir, storage.QuarantineDir = dir, quarantine })
+	if err := storage.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storeFile(t *testing.T, id, content string) *database.File {
+	t.Helper()
+	path := storage.Path(id)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &database.File{ID: id, Path: path, Version: 1}
+}
+
+func TestScanStoredFile(t *testing.T) {
+	useScanner(t, signatureScanner{"MALWARE"})
+	useTempStorage(t)
+
+	tests := []struct {
+		content string
+		want    scan.Result
+	}{
+		{"just a document", scan.Result{}},
+		{"header MALWARE", scan.Result{Infected: true, Signature: "Test-Signature"}},
+	}
+	for i, tt := range tests {
+		file := storeFile(t, string(rune('a'+i)), tt.content)
+		got, err := scanStoredFile(context.Background(), *file)
+		if err != nil {
+			t.Fatalf("scanStoredFile: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("scanStoredFile(%q) = %+v, want %+v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestQuarantineFile(t *testing.T) {
+	useTempStorage(t)
+	file := storeFile(t, "infected", "MALWARE")
+	original := file.Path
+
+	var recorded []string
+	err := quarantineFile(context.Background(), file, func(path string) error {
+		recorded = append(recorded, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("quarantineFile: %v", err)
+	}
+
+	want := storage.QuarantinePath(original)
+	if len(recorded) != 1 || recorded[0] != want {
+		t.Errorf("recorded paths = %q, want [%q]", recorded, want)
+	}
+	if file.Path != want {
+		t.Errorf("file.Path = %q, want %q", file.Path, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("quarantined content: %v", err)
+	}
+	if _, err := os.Stat(original); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("original content still present: %v", err)
+	}
+}
+
+func TestQuarantineFileRecordFails(t *testing.T) {
+	useTempStorage(t)
+	file := storeFile(t, "infected", "MALWARE")
+	original := file.Path
+
+	dbErr := errors.New("database unavailable")
+	err := quarantineFile(context.Background(), file, func(string) error { return dbErr })
+	if !errors.Is(err, dbErr) {
+		t.Fatalf("quarantineFile error = %v, want %v", err, dbErr)
+	}
+
+	// The content must still be where the file row says it is, so the
+	// retried job can scan it again.
+	if file.Path != original {
+		t.Errorf("file.Path = %q, want %q", file.Path, original)
+	}
+	if _, err := os.Stat(original); err != nil {
+		t.Errorf("original content: %v", err)
+	}
+	if _, err := os.Stat(storage.QuarantinePath(original)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("content was quarantined before the verdict was recorded: %v", err)
+	}
+}
+
+func TestQuarantineFileMoveFails(t *testing.T) {
+	useTempStorage(t)
+	file := &database.File{ID: "gone", Path: storage.Path("gone"), Version: 1}
+
+	var recorded []string
+	err := quarantineFile(context.Background(), file, func(path string) error {
+		recorded = append(recorded, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("quarantineFile: %v", err)
+	}
+
+	// The row is pointed back at the content's real location
+	want := []string{storage.QuarantinePath(file.Path), file.Path}
+	if len(recorded) != 2 || recorded[0] != want[0] || recorded[1] != want[1] {
+		t.Errorf("recorded paths = %q, want %q", recorded, want)
+	}
+}
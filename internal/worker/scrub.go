@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/schedule"
+	"github.com/manojkp08/22BCE11415_Backend/internal/throttle"
+)
+
+// TaskScrub names the scrub task's lock and run history.
+const TaskScrub = "scrub"
+
+// ScrubOptions configures RunScrub.
+type ScrubOptions struct {
+	// RateBPS caps how many bytes per second are read, so a scrub does not
+	// starve downloads of disk bandwidth. Zero is unlimited.
+	RateBPS int64
+}
+
+// ScrubStats are the counters stored with each scrub run.
+type ScrubStats struct {
+	FilesChecked  int      `json:"files_checked"`
+	BytesChecked  int64    `json:"bytes_checked"`
+	Corrupt       int      `json:"corrupt"`
+	MarkedCorrupt int      `json:"marked_corrupt"`
+	Restored      int      `json:"restored"`
+	Hashed        int      `json:"hashed"`
+	Skipped       int      `json:"skipped"`
+	Failed        int      `json:"failed"`
+	CorruptFiles  []string `json:"corrupt_files,omitempty"`
+}
+
+// StartScrubWorker runs a scrub once at startup and then whenever sched is
+// due, on whichever replica holds its lock.
+func StartScrubWorker(ctx context.Context, sched schedule.Schedule, opts ScrubOptions) {
+	StartScheduled(ctx, TaskScrub, sched, func(ctx context.Context, trigger string) error {
+		_, _, err := RunScrub(ctx, trigger, opts)
+		return err
+	})
+}
+
+// RunScrub re-reads the current content of every file and compares it with
+// the SHA-256 recorded at upload, to catch bit rot. Files that no longer
+// match, or whose encrypted content fails authentication, are marked
+// corrupt and stop being served; marks are cleared when the content
+// verifies again. Content stored before uploads were hashed has its digest
+// recorded instead. It returns database.ErrLockHeld when another instance
+// is already scrubbing.
+func RunScrub(ctx context.Context, trigger string, opts ScrubOptions) (*database.MaintenanceRun, *ScrubStats, error) {
+	var stats ScrubStats
+	run, err := runMaintenance(ctx, TaskScrub, trigger, false, &stats, func(ctx context.Context) error {
+		limiter := throttle.NewLimiter(opts.RateBPS, 0)
+		var cursor database.FileCursor
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			files, err := database.GetFilesPage(ctx, cursor, reconcileBatchSize)
+			if err != nil {
+				return err
+			}
+
+			for _, file := range files {
+				if file.ContentMissingAt != nil {
+					stats.Skipped++
+					continue
+				}
+				scrubFile(logging.With(ctx, slog.String("file_id", file.ID)), limiter, file, &stats)
+			}
+
+			if len(files) < reconcileBatchSize {
+				return nil
+			}
+			last := files[len(files)-1]
+			cursor = database.FileCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+	})
+	return run, &stats, err
+}
+
+// scrubFile verifies one file's current content and records the outcome.
+func scrubFile(ctx context.Context, limiter *throttle.Limiter, file database.File, stats *ScrubStats) {
+	digest, n, err := hashContent(ctx, limiter, &file)
+	stats.BytesChecked += n
+	if errors.Is(err, os.ErrNotExist) {
+		// Moved to quarantine or deleted since the page was read;
+		// reconciliation reports content that is really gone.
+		stats.Skipped++
+		return
+	}
+	corrupt := errors.Is(err, encryption.ErrCorrupt)
+	if err != nil && !corrupt {
+		slog.ErrorContext(ctx, "reading stored file failed", "path", file.Path, "error", err)
+		stats.Failed++
+		return
+	}
+	stats.FilesChecked++
+
+	if !corrupt && file.SHA256 == "" {
+		if err := database.SetFileChecksum(ctx, file.ID, file.Version, digest); err != nil {
+			slog.ErrorContext(ctx, "recording file checksum failed", "error", err)
+			stats.Failed++
+			return
+		}
+		stats.Hashed++
+		return
+	}
+	corrupt = corrupt || digest != file.SHA256
+
+	if !corrupt {
+		if file.CorruptAt != nil {
+			if _, err := database.SetContentCorrupt(ctx, file.ID, file.Version, false); err != nil {
+				slog.ErrorContext(ctx, "clearing corrupt content mark failed", "error", err)
+				stats.Failed++
+				return
+			}
+			stats.Restored++
+		}
+		return
+	}
+
+	stats.Corrupt++
+	if len(stats.CorruptFiles) < maxReported {
+		stats.CorruptFiles = append(stats.CorruptFiles, file.ID)
+	}
+	slog.ErrorContext(ctx, "stored content is corrupt", "path", file.Path, "version", file.Version)
+	marked, err := database.SetContentCorrupt(ctx, file.ID, file.Version, true)
+	if err != nil {
+		slog.ErrorContext(ctx, "marking content corrupt failed", "error", err)
+		stats.Failed++
+		return
+	}
+	if marked {
+		stats.MarkedCorrupt++
+	}
+}
+
+// hashContent returns the hex SHA-256 of file's decrypted content, read
+// at the pace limiter allows, and how many bytes were read.
+func hashContent(ctx context.Context, limiter *throttle.Limiter, file *database.File) (string, int64, error) {
+	content, err := OpenContent(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer content.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, limiter.Reader(ctx, "", content))
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/jobs"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+	"github.com/manojkp08/22BCE11415_Backend/internal/scan"
+	"github.com/manojkp08/22BCE11415_Backend/internal/storage"
+	"github.com/manojkp08/22BCE11415_Backend/internal/thumbnail"
+	"github.com/manojkp08/22BCE11415_Backend/internal/websocket"
+)
+
+// KindGenerateThumbnails renders the thumbnails of an image that passed
+// its malware scan.
+const KindGenerateThumbnails = "generate_thumbnails"
+
+// ThumbnailPurpose names the derived encryption key of a thumbnail size
+// rendered from a version of a file.
+func ThumbnailPurpose(version int, size string) string {
+	if version > 1 {
+		return VersionPurpose(version) + "/thumbnail-" + size
+	}
+	return "thumbnail-" + size
+}
+
+// GenerateThumbnails renders every thumbnail size of an image file, stores
+// them next to the file and tells the owner over the WebSocket. Images
+// that cannot be decoded fail permanently.
+func GenerateThumbnails(ctx context.Context, job *database.Job) error {
+	var payload FileJob
+	if err := jobs.Decode(job, &payload); err != nil {
+		return err
+	}
+	file, err := database.GetFileByID(ctx, payload.FileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return jobs.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if file.ScanStatus != scan.StatusClean || !thumbnail.Supported(file.MimeType) || payload.stale(file) {
+		return nil
+	}
+	ctx = logging.With(ctx, slog.String("file_id", file.ID), slog.String("user_id", file.UserID))
+
+	src, err := OpenContent(file)
+	if err != nil {
+		return err
+	}
+	thumbs, err := thumbnail.Generate(src)
+	src.Close()
+	if err != nil {
+		slog.WarnContext(ctx, "generating thumbnails failed", "error", err)
+		return jobs.Permanent(err)
+	}
+
+	var dataKey []byte
+	if file.KeyID != "" {
+		if encryption.Default == nil {
+			return encryption.ErrNoKeyring
+		}
+		if dataKey, err = encryption.Default.Unwrap(file.KeyID, file.WrappedKey, file.ID); err != nil {
+			return err
+		}
+	}
+
+	file.Thumbnails = nil
+	for _, thumb := range thumbs {
+		path := storage.ThumbnailPath(file.ID, thumb.Size)
+		size, err := writeThumbnail(path, file.ID, ThumbnailPurpose(file.Version, thumb.Size), thumb, dataKey)
+		if err != nil {
+			return fmt.Errorf("storing %s thumbnail: %w", thumb.Size, err)
+		}
+		if err := database.SaveThumbnail(ctx, database.Thumbnail{
+			FileID:   file.ID,
+			Size:     thumb.Size,
+			Path:     path,
+			MimeType: thumb.MimeType,
+			Width:    thumb.Width,
+			Height:   thumb.Height,
+			Bytes:    size,
+		}); err != nil {
+			os.Remove(path)
+			return err
+		}
+		file.Thumbnails = append(file.Thumbnails, thumb.Size)
+	}
+
+	slog.InfoContext(ctx, "thumbnails generated", "sizes", len(thumbs))
+	websocket.BroadcastToUser(file.UserID, gin.H{"event": "thumbnail_ready", "file": file})
+	return nil
+}
+
+// writeThumbnail stores a thumbnail, encrypted for purpose when the file
+// is, and returns its decrypted size.
+func writeThumbnail(path, fileID, purpose string, thumb thumbnail.Thumbnail, dataKey []byte) (_ int64, err error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	if dataKey == nil {
+		n, err := out.Write(thumb.Data)
+		return int64(n), err
+	}
+	key, err := encryption.DeriveKey(dataKey, purpose)
+	if err != nil {
+		return 0, err
+	}
+	w, err := encryption.NewWriter(out, key, encryption.DerivedID(fileID, purpose))
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(thumb.Data)
+	if err != nil {
+		return 0, err
+	}
+	return int64(n), w.Close()
+}
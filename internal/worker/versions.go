@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/encryption"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+)
+
+// VersionPurpose names the derived encryption key of a file version's
+// content. The first version is encrypted with the file's data key itself.
+func VersionPurpose(version int) string {
+	if version <= 1 {
+		return ""
+	}
+	return "version-" + strconv.Itoa(version)
+}
+
+// OpenContent opens the stored content of file's current version,
+// decrypting it if needed. file.WrappedKey must be loaded.
+func OpenContent(file *database.File) (encryption.File, error) {
+	return encryption.OpenDerived(file.Path, file.ID, file.KeyID, file.WrappedKey, VersionPurpose(file.Version))
+}
+
+// pruneVersions deletes old versions beyond opts.KeepVersions per file or
+// older than opts.VersionMaxAge, never the current one.
+func pruneVersions(ctx context.Context, opts CleanupOptions, stats *CleanupStats) error {
+	var cutoff time.Time
+	if opts.VersionMaxAge > 0 {
+		cutoff = time.Now().Add(-opts.VersionMaxAge)
+	}
+	var cursor database.VersionCursor
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		versions, err := database.GetPrunableVersions(ctx, opts.KeepVersions, cutoff, cursor, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range versions {
+			versionCtx := logging.With(ctx, slog.String("file_id", v.FileID), slog.Int("version", v.Version))
+			if opts.DryRun {
+				slog.InfoContext(versionCtx, "would delete old file version", "created_at", v.CreatedAt, "size", v.Size)
+				stats.VersionsPruned++
+				continue
+			}
+
+			deleted, err := database.DeleteFileVersion(versionCtx, v.FileID, v.Version)
+			if err != nil {
+				slog.ErrorContext(versionCtx, "deleting file version failed", "error", err)
+				stats.Failed++
+				continue
+			}
+			if !deleted {
+				// Deleted, or restored as current, in the meantime.
+				continue
+			}
+			if err := os.Remove(v.Path); err != nil && !os.IsNotExist(err) {
+				slog.ErrorContext(versionCtx, "deleting stored version failed", "path", v.Path, "error", err)
+				stats.Failed++
+				continue
+			}
+			stats.VersionsPruned++
+			stats.BytesFreed += v.Size
+		}
+
+		if len(versions) < opts.BatchSize {
+			return nil
+		}
+		last := versions[len(versions)-1]
+		cursor = database.VersionCursor{FileID: last.FileID, Version: last.Version}
+	}
+}
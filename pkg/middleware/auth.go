@@ -1,15 +1,24 @@
 package middleware
 
 import (
+	"log/slog"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/manojkp08/22BCE11415_Backend/internal/auth"
 	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
 )
 
+// AuthMiddleware authenticates the request with the JWT in its
+// Authorization header, unless PresignedAuth already has.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if _, ok := c.Get("user"); ok {
+			c.Next()
+			return
+		}
+
 		tokenString := c.GetHeader("Authorization")
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header required"})
@@ -19,12 +28,16 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		claims, err := auth.ValidateToken(tokenString)
 		if err != nil {
+			if auth.IsForged(err) {
+				rejectCredentials(c, http.StatusUnauthorized, "invalid token")
+				return
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
-		user, err := database.GetUserByID(claims.UserID)
+		user, err := database.GetUserByID(c.Request.Context(), claims.UserID)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
 			c.Abort()
@@ -32,6 +45,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		c.Set("user", user)
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), slog.String("user_id", user.ID)))
 		c.Next()
 	}
 }
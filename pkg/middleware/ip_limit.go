@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ParsePrefixes parses IP addresses and CIDR ranges. A bare address is
+// treated as a single-host range.
+func ParsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the client address as resolved by gin, which only
+// honours X-Forwarded-For when the direct peer is a trusted proxy.
+func clientAddr(c *gin.Context) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(c.ClientIP())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// IPFilter rejects clients in deny and, when allow is non-empty, clients
+// outside allow. Deny entries take precedence.
+func IPFilter(allow, deny []netip.Prefix) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(allow) == 0 && len(deny) == 0 {
+			c.Next()
+			return
+		}
+
+		addr, ok := clientAddr(c)
+		if !ok || containsAddr(deny, addr) || (len(allow) > 0 && !containsAddr(allow, addr)) {
+			slog.WarnContext(c.Request.Context(), "client address rejected by IP filter", "client_ip", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "access denied"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SubnetKey groups client addresses into IPv4 and IPv6 networks of the
+// given prefix lengths, so a client can't dodge a limit by rotating
+// through addresses in the same allocation.
+func SubnetKey(ipv4Bits, ipv6Bits int) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		addr, ok := clientAddr(c)
+		if !ok {
+			return "unknown"
+		}
+		bits := ipv6Bits
+		if addr.Is4() {
+			bits = ipv4Bits
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return addr.String()
+		}
+		return prefix.String()
+	}
+}
+
+// IPRateLimit limits requests per client network under policy. Unlike
+// RateLimit it needs no authenticated user, so it protects public routes.
+func IPRateLimit(policy RateLimitPolicy, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enforce(c, policy, "rate_limit:"+policy.Name+":ip:"+key(c)) {
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
+)
+
+// LockoutPolicy locks a key out after MaxFailures failed attempts within
+// Window. Each consecutive lockout doubles, starting at BaseDuration and
+// capped at MaxDuration; the escalation is forgotten after ResetAfter
+// without a lockout.
+type LockoutPolicy struct {
+	Name         string
+	MaxFailures  int
+	Window       time.Duration
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+	ResetAfter   time.Duration
+}
+
+// recordFailureScript returns {lockout_ms, imposed}: the time left on a
+// lockout already in force, or the lockout this failure imposed (imposed is
+// 1), or 0 when the key is not locked out.
+var recordFailureScript = redis.NewScript(`
+local locked = redis.call('PTTL', KEYS[2])
+if locked > 0 then
+	return {locked, 0}
+end
+
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if failures < tonumber(ARGV[1]) then
+	return {0, 0}
+end
+
+redis.call('DEL', KEYS[1])
+local level = redis.call('INCR', KEYS[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[5])
+
+local lockout = tonumber(ARGV[3]) * 2 ^ (level - 1)
+lockout = math.floor(math.min(lockout, tonumber(ARGV[4])))
+redis.call('SET', KEYS[2], level, 'PX', lockout)
+return {lockout, 1}
+`)
+
+func (p LockoutPolicy) keys(key string) []string {
+	prefix := "lockout:" + p.Name + ":"
+	return []string{prefix + "failures:" + key, prefix + "locked:" + key, prefix + "level:" + key}
+}
+
+// lockoutState returns how long key stays locked out, or 0, and whether it
+// has failed attempts on record.
+func lockoutState(ctx context.Context, policy LockoutPolicy, key string) (time.Duration, bool, error) {
+	keys := policy.keys(key)
+	var ttl *redis.DurationCmd
+	var failures *redis.IntCmd
+	_, err := cache.Client.WithContext(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+		ttl = pipe.PTTL(keys[1])
+		failures = pipe.Exists(keys[0])
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return max(ttl.Val(), 0), failures.Val() > 0, nil
+}
+
+// RecordFailure counts a failed attempt for key. It returns the lockout in
+// force afterwards, if any, and whether this failure imposed it.
+func RecordFailure(ctx context.Context, policy LockoutPolicy, key string) (time.Duration, bool, error) {
+	raw, err := recordFailureScript.Run(
+		cache.Client.WithContext(ctx),
+		policy.keys(key),
+		policy.MaxFailures,
+		policy.Window.Milliseconds(),
+		policy.BaseDuration.Milliseconds(),
+		policy.MaxDuration.Milliseconds(),
+		policy.ResetAfter.Milliseconds(),
+	).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	values, ok := raw.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, false, fmt.Errorf("unexpected lockout script reply %v", raw)
+	}
+	ms, ok1 := values[0].(int64)
+	imposed, ok2 := values[1].(int64)
+	if !ok1 || !ok2 {
+		return 0, false, fmt.Errorf("unexpected lockout script reply %v", raw)
+	}
+	return time.Duration(ms) * time.Millisecond, imposed == 1, nil
+}
+
+// ResetFailures clears the failure count for key after a successful
+// attempt. The escalation level is left to expire on its own, so a success
+// from someone sharing the key does not wipe out lockouts others earned.
+func ResetFailures(ctx context.Context, policy LockoutPolicy, key string) error {
+	return cache.Client.WithContext(ctx).Del(policy.keys(key)[0]).Err()
+}
+
+// recordFailure counts a failed attempt and reports the lockout in force
+// afterwards, logging when this attempt imposed it.
+func recordFailure(ctx context.Context, policy LockoutPolicy, key string) time.Duration {
+	lockout, imposed, err := RecordFailure(ctx, policy, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "recording failed attempt failed", "policy", policy.Name, "error", err)
+		return 0
+	}
+	if imposed {
+		slog.WarnContext(ctx, "client locked out after repeated failures",
+			"policy", policy.Name, "key", key, "lockout", lockout.String())
+	}
+	return lockout
+}
+
+// tooManyAttempts rejects a locked-out client with 429.
+func tooManyAttempts(c *gin.Context, policy LockoutPolicy, remaining time.Duration) {
+	retryAfter := int64(math.Ceil(remaining.Seconds()))
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	metrics.RateLimitRejections.WithLabelValues(policy.Name).Inc()
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":       "too many failed attempts",
+		"retry_after": retryAfter,
+	})
+}
+
+// BruteForceGuard rejects locked-out clients with 429 and treats 401 and
+// 403 responses from the wrapped handler as failed attempts. Use it on
+// endpoints that check a secret supplied by an anonymous client, such as
+// OAuth callbacks or password-protected share links.
+func BruteForceGuard(policy LockoutPolicy, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		k := key(c)
+
+		remaining, failing, err := lockoutState(ctx, policy, k)
+		if err != nil {
+			slog.ErrorContext(ctx, "lockout check failed", "policy", policy.Name, "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if remaining > 0 {
+			tooManyAttempts(c, policy, remaining)
+			return
+		}
+
+		c.Next()
+
+		switch status := c.Writer.Status(); {
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			recordFailure(ctx, policy, k)
+		case status < http.StatusBadRequest && failing:
+			if err := ResetFailures(ctx, policy, k); err != nil {
+				slog.WarnContext(ctx, "resetting failed attempts failed", "policy", policy.Name, "error", err)
+			}
+		}
+	}
+}
+
+// credentialGuardKey holds the CredentialGuard of a request in its context.
+const credentialGuardKey = "credential_guard"
+
+type credentialGuard struct {
+	policy LockoutPolicy
+	key    string
+}
+
+// CredentialGuard locks a client out after repeated forged or malformed
+// bearer tokens or presigned URL signatures, as reported by AuthMiddleware
+// and PresignedAuth, which must run after it. Expired or missing
+// credentials are not counted, since a client network behind NAT sees
+// plenty of those from honest users, and requests with valid credentials
+// never reach Redis.
+func CredentialGuard(policy LockoutPolicy, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(credentialGuardKey, credentialGuard{policy: policy, key: key(c)})
+		c.Next()
+	}
+}
+
+// rejectCredentials aborts a request whose credentials are forged or
+// malformed with status, counting the attempt under the request's
+// CredentialGuard, if any. Locked-out clients get 429 instead.
+func rejectCredentials(c *gin.Context, status int, message string) {
+	if v, ok := c.Get(credentialGuardKey); ok {
+		guard := v.(credentialGuard)
+		if lockout := recordFailure(c.Request.Context(), guard.policy, guard.key); lockout > 0 {
+			tooManyAttempts(c, guard.policy, lockout)
+			return
+		}
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": message})
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/auth"
+)
+
+var testLockout = LockoutPolicy{
+	Name:         "test",
+	MaxFailures:  3,
+	Window:       time.Minute,
+	BaseDuration: time.Minute,
+	MaxDuration:  time.Hour,
+	ResetAfter:   24 * time.Hour,
+}
+
+func signToken(t *testing.T, key string, expiresAt time.Time) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{
+		UserID:         "u1",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix()},
+	})
+	signed, err := token.SignedString([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func credentialRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CredentialGuard(testLockout, func(*gin.Context) string { return "net" }), AuthMiddleware())
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func getWithToken(router http.Handler, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", token)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCredentialGuardLocksOutForgedTokens(t *testing.T) {
+	useMiniredis(t)
+	auth.InitJWT("server secret", time.Hour)
+	router := credentialRouter()
+
+	forged := []string{
+		signToken(t, "guessed secret", time.Now().Add(time.Hour)),
+		"not.a.jwt",
+		"garbage",
+	}
+	for _, token := range forged[:testLockout.MaxFailures-1] {
+		if w := getWithToken(router, token); w.Code != http.StatusUnauthorized {
+			t.Fatalf("forged token %q = %d, want 401", token, w.Code)
+		}
+	}
+	w := getWithToken(router, forged[testLockout.MaxFailures-1])
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("failure %d = %d, want 429", testLockout.MaxFailures, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestCredentialGuardIgnoresExpiredTokens(t *testing.T) {
+	clock := useMiniredis(t)
+	auth.InitJWT("server secret", time.Hour)
+	router := credentialRouter()
+
+	expired := signToken(t, "server secret", time.Now().Add(-time.Minute))
+	for i := 0; i < 2*testLockout.MaxFailures; i++ {
+		if w := getWithToken(router, expired); w.Code != http.StatusUnauthorized {
+			t.Fatalf("expired token %d = %d, want 401", i+1, w.Code)
+		}
+		if w := getWithToken(router, ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("missing token %d = %d, want 401", i+1, w.Code)
+		}
+	}
+	if keys := clock.m.Keys(); len(keys) != 0 {
+		t.Errorf("Redis keys = %q, want none for expired or missing tokens", keys)
+	}
+}
+
+func TestBruteForceGuardSuccessKeepsEscalation(t *testing.T) {
+	clock := useMiniredis(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(BruteForceGuard(testLockout, func(*gin.Context) string { return "net" }))
+	router.GET("/", func(c *gin.Context) {
+		status, _ := strconv.Atoi(c.Query("status"))
+		c.Status(status)
+	})
+	request := func(status int) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?status="+strconv.Itoa(status), nil))
+		return w.Code
+	}
+	keys := testLockout.keys("net")
+
+	// A success with no failures on record leaves Redis alone
+	if code := request(http.StatusOK); code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", code)
+	}
+	if clock.m.Exists(keys[0]) || clock.m.Exists(keys[2]) {
+		t.Fatal("a success created lockout keys")
+	}
+
+	for i := 0; i < testLockout.MaxFailures; i++ {
+		request(http.StatusUnauthorized)
+	}
+	if code := request(http.StatusOK); code != http.StatusTooManyRequests {
+		t.Fatalf("request while locked out = %d, want 429", code)
+	}
+
+	clock.advance(testLockout.BaseDuration)
+	request(http.StatusUnauthorized)
+	if code := request(http.StatusOK); code != http.StatusOK {
+		t.Fatalf("request after the lockout = %d, want 200", code)
+	}
+	if clock.m.Exists(keys[0]) {
+		t.Error("failures survived a success")
+	}
+	if !clock.m.Exists(keys[2]) {
+		t.Fatal("a success cleared the escalation level")
+	}
+
+	// The next lockout is twice as long
+	for i := 0; i < testLockout.MaxFailures; i++ {
+		request(http.StatusUnauthorized)
+	}
+	remaining, _, err := lockoutState(t.Context(), testLockout, "net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 2*testLockout.BaseDuration {
+		t.Errorf("second lockout = %s, want %s", remaining, 2*testLockout.BaseDuration)
+	}
+}
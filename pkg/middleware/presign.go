@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/auth"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/logging"
+)
+
+// PresignedAuth authenticates requests made with a presigned URL as the
+// user who signed it, so AuthMiddleware lets them through without an
+// Authorization header. Requests without a signature are left alone.
+func PresignedAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		if !query.Has(auth.PresignSignature) {
+			c.Next()
+			return
+		}
+
+		userID, err := auth.VerifyPresigned(c.Request.Method, c.Request.URL.Path, query, c.ClientIP())
+		if errors.Is(err, auth.ErrPresignInvalid) {
+			rejectCredentials(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		user, err := database.GetUserByID(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user", user)
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), slog.String("user_id", user.ID)))
+		c.Next()
+	}
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manojkp08/22BCE11415_Backend/internal/auth"
+)
+
+func presignRouter(handlers ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(handlers...)
+	router.GET("/files/:id", func(c *gin.Context) {
+		if _, ok := c.Get("user"); ok {
+			c.String(http.StatusOK, "presigned")
+			return
+		}
+		c.String(http.StatusOK, "anonymous")
+	})
+	return router
+}
+
+func getPresigned(router http.Handler, path string, query url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil)
+	req.RemoteAddr = "203.0.113.7:4321"
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPresignedAuthRejections(t *testing.T) {
+	auth.InitPresign("secret", time.Hour)
+	query, _, err := auth.Presign(http.MethodGet, "/files/1", "u1", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound, _, err := auth.Presign(http.MethodGet, "/files/1", "u1", "198.51.100.1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := presignRouter(PresignedAuth())
+
+	expired := url.Values{}
+	for k, v := range query {
+		expired[k] = v
+	}
+	expired.Set(auth.PresignExpires, strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	malformed := url.Values{}
+	for k, v := range query {
+		malformed[k] = v
+	}
+	malformed.Set(auth.PresignExpires, "soon")
+
+	tests := []struct {
+		name  string
+		path  string
+		query url.Values
+		code  int
+	}{
+		{"wrong path", "/files/2", query, http.StatusUnauthorized},
+		{"tampered expiry", "/files/1", expired, http.StatusUnauthorized},
+		{"malformed expiry", "/files/1", malformed, http.StatusUnauthorized},
+		{"bound to another IP", "/files/1", bound, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := getPresigned(router, tt.path, tt.query); w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+
+	// Requests without a signature are left to AuthMiddleware
+	if w := getPresigned(router, "/files/1", url.Values{}); w.Code != http.StatusOK || w.Body.String() != "anonymous" {
+		t.Errorf("unsigned request = %d %q, want it passed through", w.Code, w.Body.String())
+	}
+}
+
+func TestPresignedAuthCountsForgedSignatures(t *testing.T) {
+	clock := useMiniredis(t)
+	auth.InitPresign("secret", time.Hour)
+	query, _, err := auth.Presign(http.MethodGet, "/files/1", "u1", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := presignRouter(CredentialGuard(testLockout, func(*gin.Context) string { return "net" }), PresignedAuth())
+
+	bound, _, err := auth.Presign(http.MethodGet, "/files/1", "u1", "198.51.100.1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A URL used from the wrong address is genuine, so it is not counted
+	if w := getPresigned(router, "/files/1", bound); w.Code != http.StatusForbidden {
+		t.Fatalf("IP mismatch = %d, want 403", w.Code)
+	}
+	if keys := clock.m.Keys(); len(keys) != 0 {
+		t.Fatalf("Redis keys = %q, want none for a genuine URL", keys)
+	}
+
+	for i := 1; i < testLockout.MaxFailures; i++ {
+		if w := getPresigned(router, "/files/2", query); w.Code != http.StatusUnauthorized {
+			t.Fatalf("forged signature %d = %d, want 401", i, w.Code)
+		}
+	}
+	if w := getPresigned(router, "/files/2", query); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("forged signature %d = %d, want 429", testLockout.MaxFailures, w.Code)
+	}
+}
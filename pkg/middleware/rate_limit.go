@@ -2,17 +2,178 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
+	"github.com/google/uuid"
 	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
 	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+	"github.com/manojkp08/22BCE11415_Backend/internal/metrics"
 )
 
-func RateLimit(limit int, window time.Duration) gin.HandlerFunc {
+type Algorithm string
+
+const (
+	// SlidingLog counts every request made in the trailing window.
+	SlidingLog Algorithm = "sliding_log"
+	// TokenBucket refills Limit tokens evenly over Window and allows bursts
+	// of up to Limit requests.
+	TokenBucket Algorithm = "token_bucket"
+)
+
+// RateLimitPolicy describes one limit. Name namespaces the Redis keys so
+// different routes keep independent counters.
+type RateLimitPolicy struct {
+	Name      string
+	Limit     int
+	Window    time.Duration
+	Algorithm Algorithm
+}
+
+type rateLimitResult struct {
+	Allowed    bool
+	Remaining  int64
+	Reset      time.Time
+	RetryAfter time.Duration
+}
+
+// Both scripts use the Redis server clock so every replica agrees on time
+// (calling TIME before writes needs Redis 5+), and return
+// {allowed, remaining, reset_ms, retry_after_ms}.
+var slidingLogScript = redis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local reset = now + window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window
+end
+
+local retry = 0
+if allowed == 0 then
+	retry = reset - now
+end
+return {allowed, limit - count, reset, retry}
+`)
+
+var tokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local rate = capacity / window
+
+local state = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', key, window)
+
+local reset = now + math.ceil((capacity - tokens) / rate)
+return {allowed, math.floor(tokens), reset, retry}
+`)
+
+// allow atomically records a request against key under policy.
+func allow(ctx context.Context, policy RateLimitPolicy, key string) (rateLimitResult, error) {
+	script := slidingLogScript
+	if policy.Algorithm == TokenBucket {
+		script = tokenBucketScript
+	}
+
+	raw, err := script.Run(
+		cache.Client.WithContext(ctx),
+		[]string{key},
+		policy.Window.Milliseconds(), policy.Limit, uuid.New().String(),
+	).Result()
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+
+	values, ok := raw.([]interface{})
+	if !ok || len(values) != 4 {
+		return rateLimitResult{}, fmt.Errorf("unexpected rate limit script reply %v", raw)
+	}
+	nums := make([]int64, len(values))
+	for i, v := range values {
+		if nums[i], ok = v.(int64); !ok {
+			return rateLimitResult{}, fmt.Errorf("unexpected rate limit script reply %v", raw)
+		}
+	}
+
+	return rateLimitResult{
+		Allowed:    nums[0] == 1,
+		Remaining:  max(nums[1], 0),
+		Reset:      time.UnixMilli(nums[2]),
+		RetryAfter: time.Duration(nums[3]) * time.Millisecond,
+	}, nil
+}
+
+// enforce applies policy to key, writes the X-RateLimit-* headers and
+// aborts the request when the limit is exceeded. It reports whether the
+// request may continue.
+func enforce(c *gin.Context, policy RateLimitPolicy, key string) bool {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 500*time.Millisecond)
+	defer cancel()
+
+	result, err := allow(ctx, policy, key)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "rate limit check failed", "policy", policy.Name, "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return false
+	}
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(policy.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(result.Reset.Unix(), 10))
+
+	if !result.Allowed {
+		retryAfter := int64(math.Ceil(result.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+		metrics.RateLimitRejections.WithLabelValues(policy.Name).Inc()
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":       "rate limit exceeded",
+			"retry_after": retryAfter,
+		})
+		return false
+	}
+	return true
+}
+
+// RateLimit limits authenticated requests per user under policy. It must
+// run after AuthMiddleware.
+func RateLimit(policy RateLimitPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user from context
 		user, exists := c.Get("user")
@@ -22,40 +183,11 @@ func RateLimit(limit int, window time.Duration) gin.HandlerFunc {
 		}
 
 		userID := user.(*database.User).ID
-		key := "rate_limit:" + userID
-
-		// Use request context with timeout
-		_, cancel := context.WithTimeout(c.Request.Context(), 500*time.Millisecond)
-		defer cancel()
-
-		// Get current count
-		currentStr, err := cache.Client.Get(key).Result()
-		if err != nil && err != redis.Nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-			return
-		}
-
-		current, _ := strconv.Atoi(currentStr)
-		if current >= limit {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error":       "rate limit exceeded",
-				"retry_after": window.String(),
-			})
+		key := "rate_limit:" + policy.Name + ":user:" + userID
+		if !enforce(c, policy, key) {
 			return
 		}
 
-		// Increment count
-		_, err = cache.Client.Incr(key).Result()
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-			return
-		}
-
-		// Set expiry if first request
-		if current == 0 {
-			cache.Client.Expire(key, window)
-		}
-
 		c.Next()
 	}
 }
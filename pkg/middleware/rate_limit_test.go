@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/manojkp08/22BCE11415_Backend/internal/cache"
+	"github.com/manojkp08/22BCE11415_Backend/internal/database"
+)
+
+// redisClock is the stopped clock of an in-memory Redis.
+type redisClock struct {
+	m   *miniredis.Miniredis
+	now time.Time
+}
+
+// advance moves the clock, and the expiry of keys, forward by d.
+func (c *redisClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.m.SetTime(c.now)
+	c.m.FastForward(d)
+}
+
+// useMiniredis points cache.Client at an in-memory Redis, which runs the
+// limiter scripts, and returns its clock.
+func useMiniredis(t *testing.T) *redisClock {
+	t.Helper()
+	m := miniredis.RunT(t)
+	clock := &redisClock{m: m, now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m.SetTime(clock.now)
+	cache.Client = redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() {
+		cache.Client.Close()
+		cache.Client = nil
+	})
+	return clock
+}
+
+func TestSlidingLog(t *testing.T) {
+	clock := useMiniredis(t)
+	policy := RateLimitPolicy{Name: "test", Limit: 3, Window: time.Minute, Algorithm: SlidingLog}
+	ctx := context.Background()
+
+	for want := int64(2); want >= 0; want-- {
+		result, err := allow(ctx, policy, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != want {
+			t.Fatalf("allow = %+v, want allowed with %d remaining", result, want)
+		}
+	}
+
+	clock.advance(20 * time.Second)
+	result, err := allow(ctx, policy, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatal("fourth request in the window was allowed")
+	}
+	// The oldest request leaves the window 40s from now
+	if result.RetryAfter != 40*time.Second {
+		t.Errorf("RetryAfter = %s, want 40s", result.RetryAfter)
+	}
+
+	clock.advance(40*time.Second + time.Millisecond)
+	if result, err := allow(ctx, policy, "k"); err != nil || !result.Allowed {
+		t.Fatalf("allow after the window = %+v, %v, want allowed", result, err)
+	}
+
+	// Keys are counted separately
+	if result, err := allow(ctx, policy, "other"); err != nil || result.Remaining != 2 {
+		t.Fatalf("allow for another key = %+v, %v, want 2 remaining", result, err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := useMiniredis(t)
+	policy := RateLimitPolicy{Name: "test", Limit: 2, Window: time.Second, Algorithm: TokenBucket}
+	ctx := context.Background()
+
+	// A full bucket allows a burst of Limit requests
+	for i := 0; i < 2; i++ {
+		if result, err := allow(ctx, policy, "k"); err != nil || !result.Allowed {
+			t.Fatalf("request %d = %+v, %v, want allowed", i+1, result, err)
+		}
+	}
+	result, err := allow(ctx, policy, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	// Two tokens a second refill one every 500ms
+	if result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("RetryAfter = %s, want 500ms", result.RetryAfter)
+	}
+
+	clock.advance(500 * time.Millisecond)
+	if result, err := allow(ctx, policy, "k"); err != nil || !result.Allowed {
+		t.Fatalf("allow after a refill = %+v, %v, want allowed", result, err)
+	}
+	if result, err := allow(ctx, policy, "k"); err != nil || result.Allowed {
+		t.Fatalf("allow with the bucket empty = %+v, %v, want rejected", result, err)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	useMiniredis(t)
+	policy := RateLimitPolicy{Name: "api", Limit: 1, Window: time.Minute, Algorithm: SlidingLog}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user", &database.User{ID: "u1"}) }, RateLimit(policy))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	first := httptest.NewRecorder()
+	router.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/", nil))
+	if first.Code != http.StatusOK || first.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request = %d remaining %q, want 200 with 0 remaining", first.Code, first.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	second := httptest.NewRecorder()
+	router.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/", nil))
+	if second.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", second.Code)
+	}
+	if second.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", second.Header().Get("Retry-After"))
+	}
+}